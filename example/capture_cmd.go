@@ -0,0 +1,247 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+)
+
+// replayReadTimeout 以客户端身份重放时等待每个响应的时间
+const replayReadTimeout = 5 * time.Second
+
+// runCaptureCommand 执行 capture 子命令: decode 或 replay
+func runCaptureCommand(addr string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: 用法: capture decode <文件> | capture replay --as client|server <文件>", ErrInvalidArgument)
+	}
+
+	switch args[0] {
+	case "decode":
+		if len(args) != 2 {
+			return fmt.Errorf("%w: 用法: capture decode <文件>", ErrInvalidArgument)
+		}
+		records, err := loadCapture(args[1])
+		if err != nil {
+			return err
+		}
+		return decodeCapture(os.Stdout, records, CanonicalDialect)
+	case "replay":
+		fs := flag.NewFlagSet("capture replay", flag.ContinueOnError)
+		as := fs.String("as", "", "重放哪一方: client 向 -addr 发送记录的命令, server 在 -addr 上监听并返回记录的响应")
+		if err := fs.Parse(args[1:]); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+		}
+		if fs.NArg() != 1 {
+			return fmt.Errorf("%w: 用法: capture replay --as client|server <文件>", ErrInvalidArgument)
+		}
+		records, err := loadCapture(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		switch *as {
+		case "client":
+			return replayAsClient(os.Stdout, records, addr)
+		case "server":
+			ln, err := net.Listen("tcp", addr)
+			if err != nil {
+				return fmt.Errorf("监听 %s 失败: %w", addr, err)
+			}
+			defer ln.Close()
+			fmt.Printf("在 %s 上按抓包响应, 共 %d 个连接\n", ln.Addr(), len(captureSessions(records)))
+			return replayAsServer(os.Stdout, records, ln)
+		default:
+			return fmt.Errorf("%w: --as 必须是 client 或 server, 实际为 %q", ErrInvalidArgument, *as)
+		}
+	default:
+		return fmt.Errorf("%w: 未知的 capture 子命令 %q", ErrInvalidArgument, args[0])
+	}
+}
+
+// loadCapture 读取抓包文件中的全部记录
+func loadCapture(path string) ([]*CaptureRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readCapture(f)
+}
+
+// readCapture 读取 r 中的全部记录, 文件损坏时返回已读到的记录和错误
+func readCapture(r io.Reader) ([]*CaptureRecord, error) {
+	var records []*CaptureRecord
+	for {
+		rec, err := ReadCaptureRecord(r)
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, fmt.Errorf("第 %d 条记录: %w", len(records)+1, err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// decodeCapture 逐条打印记录, 帧能解析时以缩进的 JSON 显示, 否则标记为无效帧
+//
+// 命令只检查是否为 JSON; 响应还要按 d 解析, 与客户端收到时的判断一致.
+func decodeCapture(w io.Writer, records []*CaptureRecord, d WireDialect) error {
+	invalid := 0
+	for i, rec := range records {
+		dir := "→ 发送"
+		if rec.Direction == CaptureReceived {
+			dir = "← 接收"
+		}
+		fmt.Fprintf(w, "#%d %s 连接 %d %s %d 字节\n", i+1, rec.Time.Format("15:04:05.000000"), rec.ConnID, dir, len(rec.Frame))
+
+		frame := bytes.TrimSpace(rec.Frame)
+		err := checkCaptureFrame(rec.Direction, frame, d)
+		if err != nil {
+			invalid++
+			fmt.Fprintf(w, "  ✗ 无效帧: %v\n  %s\n", err, previewBytes(rec.Frame))
+			continue
+		}
+		var indented bytes.Buffer
+		json.Indent(&indented, frame, "  ", "  ")
+		fmt.Fprintf(w, "  %s\n", indented.Bytes())
+	}
+
+	fmt.Fprintf(w, "共 %d 条记录, %d 条无效\n", len(records), invalid)
+	return nil
+}
+
+// checkCaptureFrame 检查一帧能否按其方向解析
+func checkCaptureFrame(direction byte, frame []byte, d WireDialect) error {
+	if direction == CaptureReceived {
+		_, err := d.decode(frame)
+		return err
+	}
+	var v map[string]interface{}
+	return json.Unmarshal(frame, &v)
+}
+
+// captureSession 抓包中一个连接上的记录, 按时间顺序
+type captureSession struct {
+	connID  uint32
+	records []*CaptureRecord
+}
+
+// captureSessions 按连接编号分组, 顺序为各连接第一次出现的顺序
+func captureSessions(records []*CaptureRecord) []*captureSession {
+	var sessions []*captureSession
+	byID := map[uint32]*captureSession{}
+	for _, rec := range records {
+		s, ok := byID[rec.ConnID]
+		if !ok {
+			s = &captureSession{connID: rec.ConnID}
+			byID[rec.ConnID] = s
+			sessions = append(sessions, s)
+		}
+		s.records = append(s.records, rec)
+	}
+	return sessions
+}
+
+// errReplayMismatch 重放时对端的数据与抓包不一致
+var errReplayMismatch = errors.New("重放结果与抓包不一致")
+
+// replayAsClient 按抓包中的连接依次连接 addr, 发送记录的命令, 并把响应与记录比较
+//
+// 抓包中没有响应的命令 (如超时) 只发送不等待. 有任何不一致时返回 errReplayMismatch.
+func replayAsClient(w io.Writer, records []*CaptureRecord, addr string) error {
+	sent, mismatched := 0, 0
+	for _, s := range captureSessions(records) {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err != nil {
+			return fmt.Errorf("连接失败: %w", err)
+		}
+
+		for i, rec := range s.records {
+			if rec.Direction != CaptureSent {
+				continue
+			}
+			if _, err := conn.Write(rec.Frame); err != nil {
+				conn.Close()
+				return fmt.Errorf("连接 %d: 发送失败: %w", s.connID, err)
+			}
+			sent++
+
+			if i+1 >= len(s.records) || s.records[i+1].Direction != CaptureReceived {
+				continue
+			}
+			want := s.records[i+1].Frame
+
+			buffer := make([]byte, maxFrameSize)
+			conn.SetReadDeadline(time.Now().Add(replayReadTimeout))
+			n, err := conn.Read(buffer)
+			if err != nil {
+				conn.Close()
+				return fmt.Errorf("连接 %d: 读取响应失败: %w", s.connID, err)
+			}
+			if got := buffer[:n]; !bytes.Equal(got, want) {
+				mismatched++
+				fmt.Fprintf(w, "✗ 连接 %d 命令 %s\n  抓包: %s\n  实际: %s\n",
+					s.connID, previewBytes(rec.Frame), previewBytes(want), previewBytes(got))
+			}
+		}
+		conn.Close()
+	}
+
+	fmt.Fprintf(w, "发送 %d 个命令, %d 个响应不一致\n", sent, mismatched)
+	if mismatched > 0 {
+		return fmt.Errorf("%w: %d 个响应", errReplayMismatch, mismatched)
+	}
+	return nil
+}
+
+// replayAsServer 在 ln 上依次接受连接, 每个连接对应抓包中的一个连接, 按顺序返回记录的响应
+//
+// 收到的命令与记录不同时照常返回记录的响应并报告差异, 便于在测试中复现一次会话.
+// 所有连接处理完后返回; 有任何不一致时返回 errReplayMismatch.
+func replayAsServer(w io.Writer, records []*CaptureRecord, ln net.Listener) error {
+	mismatched := 0
+	for _, s := range captureSessions(records) {
+		conn, err := ln.Accept()
+		if err != nil {
+			return fmt.Errorf("接受连接失败: %w", err)
+		}
+
+		buffer := make([]byte, maxFrameSize)
+		for i, rec := range s.records {
+			if rec.Direction == CaptureReceived {
+				if _, err := conn.Write(rec.Frame); err != nil {
+					break
+				}
+				continue
+			}
+
+			n, err := conn.Read(buffer)
+			if err != nil {
+				fmt.Fprintf(w, "连接 %d 在第 %d 条记录前关闭: %v\n", s.connID, i+1, err)
+				break
+			}
+			if got := buffer[:n]; !bytes.Equal(got, rec.Frame) {
+				mismatched++
+				fmt.Fprintf(w, "✗ 连接 %d 第 %d 条记录\n  抓包: %s\n  实际: %s\n",
+					s.connID, i+1, previewBytes(rec.Frame), previewBytes(got))
+			}
+		}
+		// 最后一个命令没有响应 (如客户端超时) 时, 像原来的服务器一样保持连接, 直到客户端关闭
+		if last := s.records[len(s.records)-1]; last.Direction == CaptureSent {
+			io.Copy(io.Discard, conn)
+		}
+		conn.Close()
+	}
+
+	if mismatched > 0 {
+		return fmt.Errorf("%w: %d 个命令", errReplayMismatch, mismatched)
+	}
+	return nil
+}
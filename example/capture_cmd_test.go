@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runCaptureScript 对 addr 执行一组固定的操作, 返回读到的值
+func runCaptureScript(t *testing.T, addr string, opts ...Option) []string {
+	t.Helper()
+	c, err := NewClient(addr, append(opts, WithDebugOutput(io.Discard))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if err := c.Put("default", "k", "v1"); err != nil {
+		t.Fatal(err)
+	}
+	v, _, err := c.Get("default", "k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	items, err := c.ScanItems("default", "", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{v}
+	for _, item := range items {
+		got = append(got, item.Key+"="+item.Value)
+	}
+	return got
+}
+
+func TestCaptureRoundTrip(t *testing.T) {
+	s := newFakeServer(t)
+	clock := NewFakeClock(time.Unix(1700000000, 123))
+	var buf bytes.Buffer
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithClock(clock), WithCapture(&buf))
+
+	if err := c.Put("default", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.Get("default", "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := readCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 连接检查的 Info, Put, 换连接后的 Get, 各有一个响应
+	want := []struct {
+		dir  byte
+		conn uint32
+		cmd  string
+		resp string // 响应中应有的片段
+	}{
+		{CaptureSent, 1, "Info", ""}, {CaptureReceived, 1, "", `"type":"Info"`},
+		{CaptureSent, 1, "Put", ""}, {CaptureReceived, 1, "", `"type":"Ok"`},
+		{CaptureSent, 2, "Get", ""}, {CaptureReceived, 2, "", `"type":"Value"`},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("%d 条记录", len(records))
+	}
+	for i, rec := range records {
+		w := want[i]
+		if rec.Direction != w.dir || rec.ConnID != w.conn || !rec.Time.Equal(clock.Now()) {
+			t.Fatalf("#%d: %c 连接 %d 时间 %v", i, rec.Direction, rec.ConnID, rec.Time)
+		}
+		if w.cmd != "" {
+			var cmd struct{ Type string }
+			if err := json.Unmarshal(rec.Frame, &cmd); err != nil || cmd.Type != w.cmd {
+				t.Fatalf("#%d: %s", i, rec.Frame)
+			}
+		} else if !bytes.Contains(rec.Frame, []byte(w.resp)) {
+			t.Fatalf("#%d: %s", i, rec.Frame)
+		}
+	}
+}
+
+func TestReadCaptureRecordRejectsDamage(t *testing.T) {
+	record := func(dir byte, frame string) []byte {
+		hdr := make([]byte, captureHeaderSize)
+		hdr[0] = dir
+		binary.BigEndian.PutUint32(hdr[13:17], uint32(len(frame)))
+		return append(hdr, frame...)
+	}
+	good := record(CaptureSent, `{"type":"Info"}`)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"记录头不完整", good[:captureHeaderSize-1]},
+		{"帧不完整", good[:len(good)-1]},
+		{"未知方向", record('X', `{}`)},
+	}
+	for _, tt := range tests {
+		records, err := readCapture(bytes.NewReader(append(append([]byte{}, good...), tt.data...)))
+		if err == nil || len(records) != 1 {
+			t.Errorf("%s: %d 条记录, err = %v", tt.name, len(records), err)
+		}
+	}
+}
+
+func TestCaptureDecodeFlagsMalformedFrames(t *testing.T) {
+	records := []*CaptureRecord{
+		{Direction: CaptureSent, ConnID: 1, Frame: []byte(`{"type":"Get","cf":"default","key":[107]}`)},
+		{Direction: CaptureReceived, ConnID: 1, Frame: []byte(`{"type":"Value","data":[118]}`)},
+		{Direction: CaptureSent, ConnID: 1, Frame: []byte("GET / HTTP/1.1\r\n")},
+		{Direction: CaptureReceived, ConnID: 1, Frame: []byte(`{"type":"Value","data":[11`)},
+	}
+	var out bytes.Buffer
+	if err := decodeCapture(&out, records, CanonicalDialect); err != nil {
+		t.Fatal(err)
+	}
+	text := out.String()
+	for _, want := range []string{"#1 ", "→ 发送", "← 接收", "\"type\": \"Get\"", "GET / HTTP", "共 4 条记录, 2 条无效"} {
+		if !strings.Contains(text, want) {
+			t.Errorf("输出缺少 %q:\n%s", want, text)
+		}
+	}
+	if n := strings.Count(text, "✗ 无效帧"); n != 2 {
+		t.Errorf("标记了 %d 个无效帧:\n%s", n, text)
+	}
+}
+
+// TestCaptureReplayAsServer 以抓包代替服务器, 客户端执行同样的操作得到同样的结果
+func TestCaptureReplayAsServer(t *testing.T) {
+	s := newFakeServer(t)
+	var buf bytes.Buffer
+	want := runCaptureScript(t, s.addr(), WithCapture(&buf))
+	records, err := readCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	var out bytes.Buffer
+	done := make(chan error, 1)
+	go func() { done <- replayAsServer(&out, records, ln) }()
+
+	got := runCaptureScript(t, ln.Addr().String())
+	if err := <-done; err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("重放得到 %q, 抓包时为 %q", got, want)
+	}
+}
+
+func TestCaptureReplayAsClient(t *testing.T) {
+	var buf bytes.Buffer
+	runCaptureScript(t, newFakeServer(t).addr(), WithCapture(&buf))
+	records, err := readCapture(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if err := replayAsClient(&out, records, newFakeServer(t).addr()); err != nil {
+		t.Fatalf("%v\n%s", err, out.String())
+	}
+
+	// 服务器上多了一个键, Info 和 Scan 的响应不同
+	s := newFakeServer(t)
+	s.put("default", "extra", "x")
+	out.Reset()
+	if err := replayAsClient(&out, records, s.addr()); !errors.Is(err, errReplayMismatch) {
+		t.Fatalf("err = %v\n%s", err, out.String())
+	}
+	if !strings.Contains(out.String(), "2 个响应不一致") {
+		t.Fatalf("输出:\n%s", out.String())
+	}
+}
+
+func TestCaptureCommandArguments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.cap")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"capture"},
+		{"capture", "bogus"},
+		{"capture", "decode"},
+		{"capture", "replay", path},
+		{"capture", "replay", "--as", "proxy", path},
+	} {
+		if err := runCommand("127.0.0.1:0", nil, args); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%q: err = %v", args, err)
+		}
+	}
+}
@@ -1,16 +1,312 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"math"
 	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// defaultMetadataCacheTTL Info 结果默认缓存时间
+	defaultMetadataCacheTTL = 3 * time.Second
+	// defaultInfoTimeout Info 默认超时, 与数据操作无关
+	defaultInfoTimeout = 2 * time.Second
+	// maxFrameSize 服务器和客户端单次读取的缓冲区大小, 更大的命令或响应无法解析
+	maxFrameSize = 8192
+)
+
 // Client TinyKV 客户端
 type Client struct {
-	conn net.Conn
+	mu     chan struct{} // 容量为 1, 串行化连接上的请求/响应; 带超时的请求限时获取
+	addr   string
+	label  string             // 进程内唯一, 为空表示未设置
+	closed atomic.Bool        // 不经过 mu, Close 不等待进行中的请求
+	done   context.Context    // Close 时结束, 用于中断等待中的请求和后台协程
+	cancel context.CancelFunc // 结束 done
+	connMu sync.Mutex         // 替换 conn 时与 mu 一起持有; Close 只持有 connMu
+	conn   net.Conn
+	connID uint32 // 每次拨号递增, 用于区分抓包中的连接
+	clock  Clock
+
+	drainReconnects atomic.Uint64
+	errorReconnects atomic.Uint64
+
+	metaCacheTTL time.Duration
+	metaCache    *metadataCache // 为 nil 表示禁用缓存
+
+	infoTimeout       time.Duration
+	staleInfoFallback bool
+
+	signer Signer
+
+	controls  *Controls
+	adminAddr string
+	admin     net.Listener
+
+	captureW io.Writer
+	debugW   io.Writer // 调试输出, 默认为标准输出
+
+	defaultScanLimit int
+	maxScanLimit     int
+	strictScanLimit  bool
+
+	errHistorySize int
+	errHistory     *errorHistory // 为 nil 表示禁用
+
+	sizeWarnThreshold int
+	sizeWarnFn        func(ValueSizeWarning)
+	sizeWatcher       *valueSizeWatcher // 为 nil 表示未启用
+
+	dryRun *dryRunLog // 为 nil 表示正常执行
+
+	journalDir string
+	journal    *requestJournal // 为 nil 表示未启用
+
+	validators map[string]func([]byte) error // 列族 -> 值校验函数
+
+	labelErr error // WithLabel 参数错误, 在 NewClient 中返回
+
+	dialect WireDialect
+
+	defaultCF string // 空列族参数映射到的列族
+	strictCF  bool   // 为 true 时空列族参数直接报错
+
+	latencyResolution  int
+	latencyLogPath     string
+	latencyLogInterval time.Duration
+	latency            *latencyRecorder // 为 nil 表示未启用
+	latencyLog         *latencyLogger
+
+	skipSanityCheck bool
+
+	deprecationFn   func(DeprecationWarning)
+	deprecatedSites sync.Map // 已报告的 "接口@调用位置"
+}
+
+// Option 客户端配置项
+type Option func(*Client)
+
+// WithLabel 为客户端设置标签, 用于区分同一进程中用途不同的客户端
+//
+// 标签出现在调试输出、错误历史、值大小告警和 Clients 中. 标签不能为空,
+// 同一进程中同时存在的客户端标签不能重复.
+func WithLabel(label string) Option {
+	return func(c *Client) {
+		if label == "" {
+			c.labelErr = fmt.Errorf("%w: 标签不能为空", ErrInvalidArgument)
+			return
+		}
+		c.label = label
+	}
+}
+
+// WithDialect 按 d 编码命令和解析响应, 用于格式与本仓库服务器不同的服务器
+func WithDialect(d WireDialect) Option {
+	return func(c *Client) {
+		c.dialect = d
+	}
+}
+
+// WithDefaultCF 设置空列族参数映射到的列族, 默认为 "default"
+func WithDefaultCF(cf string) Option {
+	return func(c *Client) {
+		c.defaultCF = cf
+	}
+}
+
+// WithStrictCF 空列族参数返回 ErrInvalidArgument, 而不是映射到默认列族
+func WithStrictCF() Option {
+	return func(c *Client) {
+		c.strictCF = true
+	}
+}
+
+// WithoutSanityCheck 连接后不检查对端是否为 TinyKV 服务器
+func WithoutSanityCheck() Option {
+	return func(c *Client) {
+		c.skipSanityCheck = true
+	}
+}
+
+// WithDeprecationWarnings 调用已废弃的接口时调用 fn, 每个调用位置只报告一次;
+// fn panic 时该次调用返回 ErrCallbackPanic
+func WithDeprecationWarnings(fn func(DeprecationWarning)) Option {
+	return func(c *Client) {
+		c.deprecationFn = fn
+	}
+}
+
+// WithClock 替换客户端使用的时钟, 用于测试
+func WithClock(clock Clock) Option {
+	return func(c *Client) {
+		c.clock = clock
+	}
+}
+
+// WithMetadataCacheTTL 设置 Info 结果的缓存时间
+func WithMetadataCacheTTL(ttl time.Duration) Option {
+	return func(c *Client) {
+		c.metaCacheTTL = ttl
+	}
+}
+
+// WithoutMetadataCache 禁用 Info 结果缓存, 每次调用都访问服务器
+func WithoutMetadataCache() Option {
+	return func(c *Client) {
+		c.metaCacheTTL = 0
+	}
+}
+
+// WithInfoTimeout 设置 Info 的超时时间, 0 表示不限时
+func WithInfoTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.infoTimeout = timeout
+	}
+}
+
+// WithStaleInfoFallback Info 超时时返回缓存中最后一次成功的结果并标记为 Stale,
+// 而不是返回错误. 禁用缓存时没有可回退的结果.
+func WithStaleInfoFallback() Option {
+	return func(c *Client) {
+		c.staleInfoFallback = true
+	}
+}
+
+// WithSigner 对每个修改类命令签名, 签名附加在 Command.Signature 中; 签名器 panic 时
+// 命令不发送, 返回 ErrCallbackPanic
+func WithSigner(s Signer) Option {
+	return func(c *Client) {
+		c.signer = s
+	}
+}
+
+// WithAdminEndpoint 在 addr 上启动 HTTP 管理接口, 通过 /controls 读取和修改运行时控制
+func WithAdminEndpoint(addr string) Option {
+	return func(c *Client) {
+		c.adminAddr = addr
+	}
+}
+
+// WithCapture 将线上收发的每一帧以二进制记录写入 w, 可用 ReadCaptureRecord 读回
+func WithCapture(w io.Writer) Option {
+	return func(c *Client) {
+		c.captureW = w
+	}
+}
+
+// WithDebugOutput 将收发的 JSON 调试输出写入 w 而不是标准输出, io.Discard 关闭输出
+func WithDebugOutput(w io.Writer) Option {
+	return func(c *Client) {
+		c.debugW = w
+	}
+}
+
+// WithErrorHistory 设置保留的失败操作条数, 0 表示不记录
+func WithErrorHistory(size int) Option {
+	return func(c *Client) {
+		c.errHistorySize = size
+	}
+}
+
+// WithDefaultScanLimit 调用方传入 limit 0 (不限制) 时改用 n, n 为负数时 NewClient 返回 ErrInvalidArgument
+func WithDefaultScanLimit(n int) Option {
+	return func(c *Client) {
+		c.defaultScanLimit = n
+	}
+}
+
+// WithMaxScanLimit 将超过 n 的 Scan limit (包括不限制) 收紧为 n
+func WithMaxScanLimit(n int) Option {
+	return func(c *Client) {
+		c.maxScanLimit = n
+	}
+}
+
+// WithStrictScanLimit 超过 WithMaxScanLimit 的 limit 返回 ErrInvalidArgument, 而不是收紧.
+// ScanPage 会多读一条, 因此它的 limit 必须小于上限.
+func WithStrictScanLimit() Option {
+	return func(c *Client) {
+		c.strictScanLimit = true
+	}
+}
+
+// WithLatencyHistogram 按命令类型记录延迟直方图, 每个 2 倍区间分为 resolution 个桶
+func WithLatencyHistogram(resolution int) Option {
+	return func(c *Client) {
+		c.latencyResolution = resolution
+	}
+}
+
+// WithLatencyLog 每隔 interval 将各命令类型在该区间内的直方图追加到 path, 每行一个
+// JSON 对象, 带有 WithLabel 设置的标签, 用于离线绘制热力图. 未设置 WithLatencyHistogram
+// 时使用默认精度.
+func WithLatencyLog(path string, interval time.Duration) Option {
+	return func(c *Client) {
+		c.latencyLogPath = path
+		c.latencyLogInterval = interval
+	}
+}
+
+// WithValueSizeWarning 写入的值超过 threshold 字节时调用 fn
+//
+// fn 在独立的协程中执行, 同一列族和键前缀每分钟最多回调一次; 回调来不及处理时
+// 告警被丢弃并计数, 写入本身不受影响. fn 中的 panic 输出到标准错误, 不影响之后的回调.
+func WithValueSizeWarning(threshold int, fn func(ValueSizeWarning)) Option {
+	return func(c *Client) {
+		c.sizeWarnThreshold = threshold
+		c.sizeWarnFn = fn
+	}
+}
+
+// WithDryRun 修改类命令 (Put, Delete, Flush) 不发送到服务器, 只记录到 DryRunLog,
+// 读操作照常执行, 因此脚本的逻辑仍然可以运行
+func WithDryRun() Option {
+	return func(c *Client) {
+		c.dryRun = &dryRunLog{}
+	}
+}
+
+// WithJournal 在 dir 中记录修改请求: 发送 Put 和 Delete 前写入意图, 收到响应后
+// 标记完成. 崩溃后以同一目录创建客户端, 再用 RecoverJournal 找出结果不确定的操作.
+func WithJournal(dir string) Option {
+	return func(c *Client) {
+		c.journalDir = dir
+	}
+}
+
+// WithValueValidator 写入 cf 的每个值在发送前先经过 validate, 失败时返回 ErrInvalidValue,
+// panic 时返回 ErrCallbackPanic
+func WithValueValidator(cf string, validate func([]byte) error) Option {
+	return func(c *Client) {
+		if c.validators == nil {
+			c.validators = map[string]func([]byte) error{}
+		}
+		c.validators[cf] = validate
+	}
 }
 
 // Command 命令结构
@@ -21,7 +317,15 @@ type Command struct {
 	Value    []byte  `json:"value,omitempty"`
 	StartKey []byte  `json:"start_key,omitempty"`
 	EndKey   *[]byte `json:"end_key,omitempty"` // 使用指针表示 Option
-	Limit    int     `json:"limit,omitempty"`
+	Limit    *int    `json:"limit,omitempty"`   // 指针: 0 表示不限制, 也需要发送
+
+	Signature *Signature `json:"signature,omitempty"`
+
+	// exactLimit 为 true 时运行时控制不收紧 Limit, 超过上限直接拒绝
+	exactLimit bool
+	// requestLabel 和 attempt 来自调用方的 ctx, 只用于错误历史
+	requestLabel string
+	attempt      int
 }
 
 // Response 响应结构
@@ -32,425 +336,3704 @@ type Response struct {
 	Error  string                 `json:"Error,omitempty"`
 }
 
-// NewClient 创建新客户端
-func NewClient(address string) (*Client, error) {
-	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
-	if err != nil {
-		return nil, fmt.Errorf("连接失败: %w", err)
+// ValueEncoding 命令和响应中键和值的编码方式
+type ValueEncoding int
+
+const (
+	// ValueEncodingString 值是 JSON 字符串, 原样使用; 只适合文本, 无效的 UTF-8 会被替换
+	ValueEncodingString ValueEncoding = iota
+	// ValueEncodingBase64 值是 Base64 编码的字符串
+	ValueEncodingBase64
+	// ValueEncodingByteArray 值是字节数组, 如 [104,105], 即 serde 对 Vec<u8> 的默认格式
+	ValueEncodingByteArray
+)
+
+// WireDialect 协议的响应格式和值编码
+//
+// Tag 和 Content 为空时, 响应是以 Value, Values, Info, Error 为字段名的平铺对象;
+// 否则响应是 {"<Tag>": 变体名, "<Content>": 数据} 形式的信封, 变体名即这些字段的名称,
+// 与 serde 的 #[serde(tag, content)] 枚举表示一致, 其他变体 (如 Ok) 视为没有数据.
+// 字段名匹配时先精确比较, 找不到再忽略大小写, 与 encoding/json 解析结构体一致.
+// Encoding 同时用于发送的命令和收到的响应.
+type WireDialect struct {
+	Tag     string
+	Content string
+
+	Value  string
+	Values string
+	Info   string
+	Error  string
+	// Found 为空表示服务器不返回该字段, 以值是否为 null 判断键是否存在;
+	// 否则该字段为 false 时视为未找到. 只用于平铺格式.
+	Found    string
+	Encoding ValueEncoding
+}
+
+// CanonicalDialect 本仓库服务器使用的方言, 也是默认值
+//
+// 对应 src/common.rs 中 Response 的 serde 表示, 例如 {"type":"Value","data":[104,105]}.
+var CanonicalDialect = WireDialect{
+	Tag:      "type",
+	Content:  "data",
+	Value:    "Value",
+	Values:   "Values",
+	Info:     "Info",
+	Error:    "Error",
+	Encoding: ValueEncodingByteArray,
+}
+
+// FlatDialect 以 Value, Values, Info, Error 为字段名的平铺格式, 值是 JSON 字符串
+var FlatDialect = WireDialect{
+	Value:    "Value",
+	Values:   "Values",
+	Info:     "Info",
+	Error:    "Error",
+	Encoding: ValueEncodingString,
+}
+
+// ShortDialect 字段名缩写的服务器分支使用的方言, 也可作为自定义方言的模板
+var ShortDialect = WireDialect{
+	Value:    "Val",
+	Values:   "Vals",
+	Info:     "Info",
+	Error:    "Err",
+	Found:    "Found",
+	Encoding: ValueEncodingBase64,
+}
+
+func (d WireDialect) validate() error {
+	if d.Value == "" || d.Values == "" || d.Info == "" || d.Error == "" {
+		return fmt.Errorf("%w: 方言的 Value, Values, Info, Error 字段名不能为空", ErrInvalidArgument)
+	}
+	if (d.Tag == "") != (d.Content == "") {
+		return fmt.Errorf("%w: 方言的 Tag 和 Content 必须同时设置", ErrInvalidArgument)
+	}
+	switch d.Encoding {
+	case ValueEncodingString, ValueEncodingBase64, ValueEncodingByteArray:
+	default:
+		return fmt.Errorf("%w: 未知的值编码: %d", ErrInvalidArgument, d.Encoding)
+	}
+	return nil
+}
+
+// wireCommand 按方言编码后的命令, 键和值已经是 JSON
+type wireCommand struct {
+	Type      string          `json:"type"`
+	CF        *string         `json:"cf,omitempty"`
+	Key       json.RawMessage `json:"key,omitempty"`
+	Value     json.RawMessage `json:"value,omitempty"`
+	StartKey  json.RawMessage `json:"start_key,omitempty"`
+	EndKey    json.RawMessage `json:"end_key,omitempty"`
+	Limit     *int            `json:"limit,omitempty"`
+	Signature *Signature      `json:"signature,omitempty"`
+}
+
+// commandFields 服务器 Command 各变体的字段 (见 src/common.rs), 为空也必须发送,
+// 缺少任何一个时服务器解析失败并关闭连接
+var commandFields = map[string]struct{ cf, key, value, scan bool }{
+	"Get":    {cf: true, key: true},
+	"Put":    {cf: true, key: true, value: true},
+	"Delete": {cf: true, key: true},
+	"Scan":   {cf: true, scan: true},
+}
+
+// marshalCommand 按方言的值编码序列化命令
+func (d WireDialect) marshalCommand(cmd Command) ([]byte, error) {
+	fields := commandFields[cmd.Type]
+
+	w := wireCommand{Type: cmd.Type, Limit: cmd.Limit, Signature: cmd.Signature}
+	if fields.cf || cmd.CF != "" {
+		w.CF = &cmd.CF
+	}
+	if fields.key || len(cmd.Key) > 0 {
+		w.Key = d.encodeBytes(cmd.Key)
+	}
+	if fields.value || len(cmd.Value) > 0 {
+		w.Value = d.encodeBytes(cmd.Value)
+	}
+	if fields.scan || len(cmd.StartKey) > 0 {
+		w.StartKey = d.encodeBytes(cmd.StartKey)
+	}
+	switch {
+	case cmd.EndKey != nil && *cmd.EndKey != nil:
+		w.EndKey = d.encodeBytes(*cmd.EndKey)
+	case cmd.EndKey != nil || fields.scan:
+		w.EndKey = json.RawMessage("null")
+	}
+	if fields.scan && w.Limit == nil {
+		unlimited := 0
+		w.Limit = &unlimited
+	}
+	return json.Marshal(w)
+}
+
+// encodeBytes 按方言编码键或值
+func (d WireDialect) encodeBytes(b []byte) json.RawMessage {
+	switch d.Encoding {
+	case ValueEncodingString:
+		data, _ := json.Marshal(string(b))
+		return data
+	case ValueEncodingBase64:
+		return json.RawMessage(`"` + base64.StdEncoding.EncodeToString(b) + `"`)
+	}
+
+	buf := make([]byte, 0, 2+4*len(b))
+	buf = append(buf, '[')
+	for i, x := range b {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		buf = strconv.AppendUint(buf, uint64(x), 10)
+	}
+	return append(buf, ']')
+}
+
+// decode 按方言解析响应, 键和值统一解码为字符串, 之后的处理与方言无关
+func (d WireDialect) decode(data []byte) (*Response, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+
+	var resp Response
+	if d.Tag != "" {
+		if err := d.decodeEnvelope(fields, &resp); err != nil {
+			return nil, err
+		}
+	} else if err := d.decodeFlat(fields, &resp); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if resp.Value, err = d.decodeBytes(resp.Value); err != nil {
+		return nil, fmt.Errorf("字段 %s: %w", d.Value, err)
+	}
+	if items, ok := resp.Values.([]interface{}); ok {
+		for _, item := range items {
+			pair, ok := item.([]interface{})
+			if !ok {
+				continue
+			}
+			for i := range pair {
+				if pair[i], err = d.decodeBytes(pair[i]); err != nil {
+					return nil, fmt.Errorf("字段 %s: %w", d.Values, err)
+				}
+			}
+		}
+	}
+
+	return &resp, nil
+}
+
+// decodeEnvelope 解析 {"<Tag>": 变体名, "<Content>": 数据} 形式的响应
+func (d WireDialect) decodeEnvelope(fields map[string]json.RawMessage, resp *Response) error {
+	raw, ok := lookupField(fields, d.Tag)
+	if !ok {
+		return fmt.Errorf("缺少字段 %s", d.Tag)
+	}
+	var variant string
+	if err := json.Unmarshal(raw, &variant); err != nil {
+		return fmt.Errorf("字段 %s: %w", d.Tag, err)
+	}
+
+	var dst interface{}
+	switch variant {
+	case d.Value:
+		dst = &resp.Value
+	case d.Values:
+		dst = &resp.Values
+	case d.Info:
+		dst = &resp.Info
+	case d.Error:
+		dst = &resp.Error
+	default:
+		return nil
+	}
+
+	content, ok := lookupField(fields, d.Content)
+	if !ok {
+		return nil
+	}
+	if err := json.Unmarshal(content, dst); err != nil {
+		return fmt.Errorf("%s 的字段 %s: %w", variant, d.Content, err)
+	}
+	return nil
+}
+
+// decodeFlat 解析以 Value, Values, Info, Error 为字段名的平铺响应
+func (d WireDialect) decodeFlat(fields map[string]json.RawMessage, resp *Response) error {
+	for _, f := range []struct {
+		name string
+		dst  interface{}
+	}{
+		{d.Value, &resp.Value},
+		{d.Values, &resp.Values},
+		{d.Info, &resp.Info},
+		{d.Error, &resp.Error},
+	} {
+		raw, ok := lookupField(fields, f.name)
+		if !ok {
+			continue
+		}
+		if err := json.Unmarshal(raw, f.dst); err != nil {
+			return fmt.Errorf("字段 %s: %w", f.name, err)
+		}
+	}
+
+	if d.Found != "" {
+		if raw, ok := lookupField(fields, d.Found); ok {
+			var found bool
+			if err := json.Unmarshal(raw, &found); err != nil {
+				return fmt.Errorf("字段 %s: %w", d.Found, err)
+			}
+			if !found {
+				resp.Value = nil
+			}
+		}
+	}
+	return nil
+}
+
+// decodeBytes 将按方言编码的键或值转为字符串; 字符串编码下原样返回, 由 decodeValue 处理
+func (d WireDialect) decodeBytes(v interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+
+	switch d.Encoding {
+	case ValueEncodingBase64:
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("期望 Base64 字符串, 实际为 %T", v)
+		}
+		b, err := base64.StdEncoding.DecodeString(str)
+		if err != nil {
+			return nil, err
+		}
+		return string(b), nil
+	case ValueEncodingByteArray:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("期望字节数组, 实际为 %T", v)
+		}
+		b := make([]byte, len(arr))
+		for i, x := range arr {
+			n, ok := x.(float64)
+			if !ok || n < 0 || n > 255 || n != float64(int(n)) {
+				return nil, fmt.Errorf("字节数组第 %d 个元素无效: %v", i, x)
+			}
+			b[i] = byte(n)
+		}
+		return string(b), nil
+	}
+	return v, nil
+}
+
+// lookupField 查找字段, 先精确匹配, 再忽略大小写
+func lookupField(fields map[string]json.RawMessage, name string) (json.RawMessage, bool) {
+	if raw, ok := fields[name]; ok {
+		return raw, true
+	}
+	for k, raw := range fields {
+		if strings.EqualFold(k, name) {
+			return raw, true
+		}
+	}
+	return nil, false
+}
+
+// NewClient 创建新客户端
+func NewClient(address string, opts ...Option) (*Client, error) {
+	c := &Client{
+		mu:             make(chan struct{}, 1),
+		addr:           address,
+		metaCacheTTL:   defaultMetadataCacheTTL,
+		infoTimeout:    defaultInfoTimeout,
+		clock:          realClock{},
+		debugW:         os.Stdout,
+		errHistorySize: defaultErrorHistorySize,
+		dialect:        CanonicalDialect,
+		defaultCF:      "default",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.labelErr != nil {
+		return nil, c.labelErr
+	}
+	if err := c.dialect.validate(); err != nil {
+		return nil, err
+	}
+	if c.defaultCF == "" {
+		return nil, fmt.Errorf("%w: 默认列族不能为空", ErrInvalidArgument)
+	}
+	if c.defaultScanLimit < 0 || c.maxScanLimit < 0 {
+		return nil, fmt.Errorf("%w: Scan limit 不能为负数: 默认 %d, 上限 %d", ErrInvalidArgument, c.defaultScanLimit, c.maxScanLimit)
+	}
+	if c.latencyLogPath != "" && c.latencyResolution == 0 {
+		c.latencyResolution = defaultLatencyResolution
+	}
+	if c.latencyResolution < 0 || c.latencyResolution > maxLatencyResolution {
+		return nil, fmt.Errorf("%w: 直方图精度必须在 1 到 %d 之间: %d", ErrInvalidArgument, maxLatencyResolution, c.latencyResolution)
+	}
+	if c.latencyLogPath != "" && c.latencyLogInterval <= 0 {
+		return nil, fmt.Errorf("%w: 延迟日志的间隔必须大于 0", ErrInvalidArgument)
+	}
+	if err := clients.register(c); err != nil {
+		return nil, err
+	}
+	c.done, c.cancel = context.WithCancel(context.Background())
+	c.controls = newControls(c.clock, c.done)
+	if c.errHistorySize > 0 {
+		c.errHistory = newErrorHistory(c.errHistorySize)
+	}
+	if c.metaCacheTTL > 0 {
+		c.metaCache = &metadataCache{ttl: c.metaCacheTTL, clock: c.clock}
+	}
+
+	conn, err := net.DialTimeout("tcp", address, 5*time.Second)
+	if err != nil {
+		clients.unregister(c)
+		return nil, fmt.Errorf("连接失败: %w", err)
+	}
+	c.conn = conn
+	c.connID++
+
+	if !c.skipSanityCheck {
+		if err := c.sanityCheck(); err != nil {
+			conn.Close()
+			clients.unregister(c)
+			return nil, err
+		}
+	}
+
+	if c.adminAddr != "" {
+		ln, err := net.Listen("tcp", c.adminAddr)
+		if err != nil {
+			conn.Close()
+			clients.unregister(c)
+			return nil, fmt.Errorf("启动管理接口失败: %w", err)
+		}
+		c.admin = ln
+
+		mux := http.NewServeMux()
+		mux.Handle("/controls", c.controls)
+		if c.errHistory != nil {
+			mux.Handle("/errors", c.errHistory)
+		}
+		mux.HandleFunc("/clients", serveClients)
+		go http.Serve(ln, mux)
+	}
+
+	if c.latencyResolution > 0 {
+		c.latency = newLatencyRecorder(c.label, c.latencyResolution)
+	}
+	if c.latencyLogPath != "" {
+		f, err := os.OpenFile(c.latencyLogPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			if c.admin != nil {
+				c.admin.Close()
+			}
+			conn.Close()
+			clients.unregister(c)
+			return nil, fmt.Errorf("打开延迟日志失败: %w", err)
+		}
+		c.latencyLog = newLatencyLogger(c.latency, f, c.latencyLogInterval, c.clock)
+	}
+
+	if c.journalDir != "" && c.dryRun == nil {
+		j, err := openJournal(c.journalDir)
+		if err != nil {
+			if c.admin != nil {
+				c.admin.Close()
+			}
+			if c.latencyLog != nil {
+				c.latencyLog.stop()
+			}
+			conn.Close()
+			clients.unregister(c)
+			return nil, err
+		}
+		c.journal = j
+	}
+
+	if c.sizeWarnFn != nil {
+		c.sizeWatcher = newValueSizeWatcher(c.label, c.sizeWarnThreshold, c.sizeWarnFn, c.clock)
+	}
+
+	return c, nil
+}
+
+// Close 关闭连接
+//
+// 不等待进行中的请求: 关闭连接使其立即以 ErrClosed 失败.
+func (c *Client) Close() error {
+	if !c.closed.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	clients.unregister(c)
+	c.cancel()
+	if c.admin != nil {
+		c.admin.Close()
+	}
+	if c.sizeWatcher != nil {
+		c.sizeWatcher.stop()
+	}
+	if c.latencyLog != nil {
+		c.latencyLog.stop()
+	}
+
+	c.connMu.Lock()
+	err := c.conn.Close()
+	c.connMu.Unlock()
+
+	// 在连接关闭之后, 被中断的请求不会再写入完成记录
+	if c.journal != nil {
+		if jerr := c.journal.close(); err == nil {
+			err = jerr
+		}
+	}
+	return err
+}
+
+// roundTrip 发送命令并读取响应
+func (c *Client) roundTrip(cmd Command) (*Response, error) {
+	return c.roundTripTimeout(cmd, 0)
+}
+
+// roundTripTimeout 发送命令并在 timeout 内读取响应, timeout 为 0 表示不限时
+//
+// 超时的响应之后仍可能到达, 继续使用该连接会被下一个命令读到, 因此超时后重建连接.
+func (c *Client) roundTripTimeout(cmd Command, timeout time.Duration) (*Response, error) {
+	start := c.clock.Now()
+
+	var journalSeq uint64
+	if c.journal != nil && mutatingCommands[cmd.Type] {
+		seq, err := c.journal.begin(cmd, start)
+		if err != nil {
+			return nil, err
+		}
+		journalSeq = seq
+	}
+
+	resp, connID, err := c.doRoundTrip(cmd, timeout)
+	// 收到响应 (包括服务器返回的错误) 或根本没有发出时结果是确定的; 其余情况留在日志中.
+	// 完成记录写入失败 (如客户端已关闭) 只会使该操作在恢复时被多报告一次, 不影响本次结果,
+	// 因此只记入错误历史.
+	if journalSeq != 0 && (err == nil || connID == 0) {
+		if jerr := c.journal.complete(journalSeq); jerr != nil {
+			c.recordError(cmd, connID, start, ErrorClassJournal, jerr.Error())
+		}
+	}
+
+	// connID 为 0 表示命令没有发出 (被拒绝或试运行), 不计入延迟
+	if c.latency != nil && connID != 0 {
+		c.latency.record(cmd.Type, c.clock.Now().Sub(start))
+	}
+	if err != nil {
+		c.recordError(cmd, connID, start, classifyError(err), err.Error())
+	} else if resp.Error != "" {
+		c.recordError(cmd, connID, start, ErrorClassServer, resp.Error)
+	}
+	// 修改后缓存的键总数已经过时, 列族也可能是新建的
+	if err == nil && connID != 0 && mutatingCommands[cmd.Type] {
+		c.InvalidateMetadataCache()
+	}
+
+	return resp, err
+}
+
+// doRoundTrip 执行一次往返, 同时返回所用连接的编号
+func (c *Client) doRoundTrip(cmd Command, timeout time.Duration) (*Response, uint32, error) {
+	if err := c.controls.admit(&cmd); err != nil {
+		return nil, 0, err
+	}
+
+	if c.dryRun != nil && skipsInDryRun(cmd.Type) {
+		entry := DryRunEntry{Time: c.clock.Now(), Command: cmd.Type, CF: cmd.CF, ValueSize: len(cmd.Value)}
+		if cmd.Key != nil {
+			entry.Key = formatKey(cmd.Key)
+		}
+		c.dryRun.record(entry)
+		return &Response{}, 0, nil
+	}
+
+	// timeout 同时限制等待前一个请求的时间: 没有超时的请求挂起时, Info 仍能按时返回
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if !c.lock(deadline) {
+		return nil, 0, &lockTimeoutError{timeout: timeout}
+	}
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return nil, 0, ErrClosed
+	}
+	connID := c.connID
+
+	if timeout > 0 {
+		conn := c.conn
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(time.Time{})
+	}
+
+	n, err := c.sendCommand(cmd)
+	if err != nil && n == 0 {
+		connID = 0 // 一个字节都没有写出, 命令确定没有发出
+	}
+	var resp *Response
+	if err == nil {
+		resp, err = c.readResponse()
+	}
+
+	if err != nil && c.closed.Load() {
+		return nil, connID, fmt.Errorf("%w: %v", ErrClosed, err)
+	}
+
+	// 超时或帧错误后连接上可能还留着这个响应的剩余部分, 会被下一个请求读到, 必须重建
+	if err != nil && (isTimeout(err) || errors.Is(err, ErrFrame)) {
+		if rerr := c.reconnectLocked(); rerr != nil {
+			return nil, connID, fmt.Errorf("%w (重建连接失败: %v)", err, rerr)
+		}
+	}
+
+	return resp, connID, err
+}
+
+// lock 获取 c.mu, deadline 为零时一直等待; 到期仍未获取时返回 false
+func (c *Client) lock(deadline time.Time) bool {
+	if deadline.IsZero() {
+		c.mu <- struct{}{}
+		return true
+	}
+
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+
+	select {
+	case c.mu <- struct{}{}:
+		return true
+	case <-t.C:
+		return false
+	}
+}
+
+func (c *Client) unlock() {
+	<-c.mu
+}
+
+// lockTimeoutError 在 timeout 内没有等到前一个请求结束; 实现 net.Error, 按超时处理
+type lockTimeoutError struct {
+	timeout time.Duration
+}
+
+func (e *lockTimeoutError) Error() string {
+	return fmt.Sprintf("等待进行中的请求超过 %v", e.timeout)
+}
+
+func (e *lockTimeoutError) Timeout() bool   { return true }
+func (e *lockTimeoutError) Temporary() bool { return true }
+
+// reconnectLocked 关闭当前连接并重新拨号, 调用方需持有 c.mu
+func (c *Client) reconnectLocked() error {
+	c.conn.Close()
+
+	conn, err := net.DialTimeout("tcp", c.addr, 5*time.Second)
+	if err != nil {
+		return fmt.Errorf("连接失败: %w", err)
+	}
+	if err := c.replaceConnLocked(conn); err != nil {
+		return err
+	}
+	c.InvalidateMetadataCache()
+	c.errorReconnects.Add(1)
+
+	return nil
+}
+
+// replaceConnLocked 换用新连接, 调用方需持有 c.mu; 期间客户端已关闭时关闭新连接
+func (c *Client) replaceConnLocked(conn net.Conn) error {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.closed.Load() {
+		conn.Close()
+		return ErrClosed
+	}
+	c.conn = conn
+	c.connID++
+	return nil
+}
+
+// ReconnectStats 重建连接的次数, 按原因区分
+type ReconnectStats struct {
+	Drain uint64 `json:"drain"` // Drain 主动发起
+	Error uint64 `json:"error"` // 请求出错 (如超时) 后发起
+}
+
+// Reconnects 返回客户端重建连接的次数
+func (c *Client) Reconnects() ReconnectStats {
+	return ReconnectStats{
+		Drain: c.drainReconnects.Load(),
+		Error: c.errorReconnects.Load(),
+	}
+}
+
+// Drain 主动换用新连接, 用于服务器滚动重启前
+//
+// 等待正在进行的请求完成后拨号, 新连接建立后才关闭旧连接, 拨号失败时继续使用
+// 旧连接. 等待当前请求期间不响应 ctx, ctx 只控制拨号.
+func (c *Client) Drain(ctx context.Context) error {
+	c.lock(time.Time{})
+	defer c.unlock()
+
+	if c.closed.Load() {
+		return ErrClosed
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return fmt.Errorf("连接失败: %w", err)
+	}
+
+	c.conn.Close()
+	if err := c.replaceConnLocked(conn); err != nil {
+		return err
+	}
+	c.InvalidateMetadataCache()
+	c.drainReconnects.Add(1)
+
+	return nil
+}
+
+const (
+	// sanityCheckTimeout 连接检查等待响应的时间
+	sanityCheckTimeout = 2 * time.Second
+	// sanityCheckPreview 诊断信息中展示的响应字节数
+	sanityCheckPreview = 64
+)
+
+// ErrNotTinyKV 对端没有按 TinyKV 协议响应
+var ErrNotTinyKV = errors.New("对端不是 TinyKV 服务器")
+
+// sanityCheck 发送 Info 并确认响应是 JSON 对象, 否则返回带诊断信息的错误
+//
+// 地址填错时对端往往是其他服务, 直接使用会在第一个请求上得到难以理解的解析错误.
+func (c *Client) sanityCheck() error {
+	data, err := c.dialect.marshalCommand(Command{Type: "Info"})
+	if err != nil {
+		return fmt.Errorf("序列化命令失败: %w", err)
+	}
+	// 结尾的换行对 JSON 解析没有影响, 但能让 HTTP 等按行解析的服务立即返回错误
+	data = append(data, '\n')
+
+	c.conn.SetDeadline(time.Now().Add(sanityCheckTimeout))
+	defer c.conn.SetDeadline(time.Time{})
+
+	c.capture(CaptureSent, data)
+	if _, err := c.conn.Write(data); err != nil {
+		return fmt.Errorf("%w: %s: 发送失败: %v", ErrNotTinyKV, c.addr, err)
+	}
+
+	buffer := make([]byte, maxFrameSize)
+	n, err := c.conn.Read(buffer)
+	got := buffer[:n]
+	if n > 0 {
+		c.capture(CaptureReceived, got)
+	}
+
+	switch {
+	case n == 0 && isTimeout(err):
+		return fmt.Errorf("%w: %s: %v 内没有响应, 可能被防火墙丢弃, 或对端在等待其他协议的握手", ErrNotTinyKV, c.addr, sanityCheckTimeout)
+	case n == 0:
+		return fmt.Errorf("%w: %s: 连接被关闭且没有任何响应 (%v), 对端可能要求 TLS 或拒绝了该请求", ErrNotTinyKV, c.addr, err)
+	case bytes.HasPrefix(got, []byte("HTTP/")):
+		return fmt.Errorf("%w: %s: 对端是 HTTP 服务, 请检查端口; 收到 %s", ErrNotTinyKV, c.addr, previewBytes(got))
+	case len(got) >= 2 && (got[0] == 0x15 || got[0] == 0x16) && got[1] == 0x03:
+		return fmt.Errorf("%w: %s: 收到 TLS 记录, 对端要求 TLS 连接; 收到 %s", ErrNotTinyKV, c.addr, previewBytes(got))
+	}
+
+	if _, err := c.dialect.decode(got); err != nil {
+		return fmt.Errorf("%w: %s: 响应不是本协议的 JSON (%v); 收到 %s", ErrNotTinyKV, c.addr, err, previewBytes(got))
+	}
+	return nil
+}
+
+// previewBytes 返回 b 开头部分的可打印形式
+func previewBytes(b []byte) string {
+	if len(b) <= sanityCheckPreview {
+		return formatKey(b)
+	}
+	return fmt.Sprintf("%s... (共 %d 字节)", formatKey(b[:sanityCheckPreview]), len(b))
+}
+
+// isTimeout 判断错误是否由超时引起
+func isTimeout(err error) bool {
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// sendCommand 发送命令, 返回写入连接的字节数
+func (c *Client) sendCommand(cmd Command) (int, error) {
+	if c.signer != nil {
+		// 签名在写出任何字节之前, 签名器 panic 时连接上没有残留
+		if err := callUser("Signer.Sign", func() error { return signCommand(c.signer, &cmd) }); err != nil {
+			return 0, err
+		}
+	}
+
+	data, err := c.dialect.marshalCommand(cmd)
+	if err != nil {
+		return 0, fmt.Errorf("序列化命令失败: %w", err)
+	}
+	if len(data) > maxFrameSize {
+		return 0, fmt.Errorf("%w: 命令 %d 字节, 超过单帧上限 %d", ErrInvalidArgument, len(data), maxFrameSize)
+	}
+
+	// 调试输出
+	fmt.Fprintf(c.debugW, "%s 发送 JSON: %s\n", c.debugTag(), string(data))
+
+	c.capture(CaptureSent, data)
+
+	n, err := c.conn.Write(data)
+	if err != nil {
+		return n, fmt.Errorf("发送命令失败: %w", err)
+	}
+
+	return n, nil
+}
+
+// ErrFrame 响应不是一个完整的帧, 连接上可能残留未读的数据
+var ErrFrame = errors.New("响应帧无效")
+
+// readResponse 读取响应
+//
+// 协议没有长度前缀, 一次读取即一个响应. 读满 maxFrameSize 时无法确认响应已经
+// 结束 (恰好 8192 字节的响应也按此处理), 与解析失败一样作为帧错误返回.
+func (c *Client) readResponse() (*Response, error) {
+	buffer := make([]byte, maxFrameSize)
+	n, err := c.conn.Read(buffer)
+	if err != nil {
+		if err == io.EOF {
+			return nil, fmt.Errorf("服务器关闭连接")
+		}
+		return nil, fmt.Errorf("读取响应失败: %w", err)
+	}
+
+	fmt.Fprintf(c.debugW, "%s 收到响应: %s\n", c.debugTag(), string(buffer[:n]))
+	c.capture(CaptureReceived, buffer[:n])
+
+	if n == maxFrameSize {
+		return nil, fmt.Errorf("%w: 响应达到单帧上限 %d 字节, 可能被截断", ErrFrame, maxFrameSize)
+	}
+	resp, err := c.dialect.decode(buffer[:n])
+	if err != nil {
+		return nil, fmt.Errorf("%w: 解析响应失败: %v", ErrFrame, err)
+	}
+
+	return resp, nil
+}
+
+// EstimateValueWireSize 估算键或值按方言编码后占用的字节数
+//
+// Base64 和字节数组是精确的; 字符串编码按原始长度计算, 不包括 JSON 转义.
+func (d WireDialect) EstimateValueWireSize(b []byte) int {
+	switch d.Encoding {
+	case ValueEncodingString:
+		return len(b) + 2
+	case ValueEncodingBase64:
+		return base64.StdEncoding.EncodedLen(len(b)) + 2
+	}
+
+	size := 2 // []
+	if len(b) > 1 {
+		size += len(b) - 1 // ,
+	}
+	for _, x := range b {
+		switch {
+		case x >= 100:
+			size += 3
+		case x >= 10:
+			size += 2
+		default:
+			size++
+		}
+	}
+	return size
+}
+
+// EstimateCommandSize 估算命令按方言序列化后的字节数, 不对键和值做实际编码
+//
+// 与 marshalCommand 一样计入服务器要求的空字段. 字符串字段 (type, cf, 签名的
+// key_id 和 alg) 以及字符串编码的键和值按原始长度计算, 不包括 JSON 转义, 因此
+// 只可能偏小, 偏差等于转义增加的字节数; 其余部分是精确的.
+func EstimateCommandSize(cmd Command, d WireDialect) int {
+	required := commandFields[cmd.Type]
+
+	size := 2 // {}
+	fields := 0
+	field := func(name string, valueSize int) {
+		if fields > 0 {
+			size++ // ,
+		}
+		fields++
+		size += len(name) + 3 + valueSize // "name":
+	}
+
+	field("type", len(cmd.Type)+2)
+	if required.cf || cmd.CF != "" {
+		field("cf", len(cmd.CF)+2)
+	}
+	if required.key || len(cmd.Key) > 0 {
+		field("key", d.EstimateValueWireSize(cmd.Key))
+	}
+	if required.value || len(cmd.Value) > 0 {
+		field("value", d.EstimateValueWireSize(cmd.Value))
+	}
+	if required.scan || len(cmd.StartKey) > 0 {
+		field("start_key", d.EstimateValueWireSize(cmd.StartKey))
+	}
+	switch {
+	case cmd.EndKey != nil && *cmd.EndKey != nil:
+		field("end_key", d.EstimateValueWireSize(*cmd.EndKey))
+	case cmd.EndKey != nil || required.scan:
+		field("end_key", len("null"))
+	}
+	switch {
+	case cmd.Limit != nil:
+		field("limit", len(strconv.Itoa(*cmd.Limit)))
+	case required.scan:
+		field("limit", len("0"))
+	}
+	if sig := cmd.Signature; sig != nil {
+		// {"key_id":"..","alg":"..","sig":..}
+		field("signature", 2+len(`"key_id":`)+len(sig.KeyID)+2+1+
+			len(`"alg":`)+len(sig.Alg)+2+1+
+			len(`"sig":`)+sigWireSize(sig.Sig))
+	}
+	return size
+}
+
+// sigWireSize 签名由 encoding/json 编码, 与方言无关: Base64 字符串, nil 编码为 null
+func sigWireSize(b []byte) int {
+	if b == nil {
+		return len("null")
+	}
+	return base64.StdEncoding.EncodedLen(len(b)) + 2
+}
+
+// EstimatePutSize 估算 Put 命令序列化后的字节数, 不含签名.
+// 超过单帧上限 (8192 字节) 的命令会被 Put 拒绝.
+func (c *Client) EstimatePutSize(cf, key, value string) int {
+	if cf == "" {
+		cf = c.defaultCF
+	}
+	return EstimateCommandSize(Command{
+		Type:  "Put",
+		CF:    cf,
+		Key:   []byte(key),
+		Value: []byte(value),
+	}, c.dialect)
+}
+
+// decodeValue 解码响应中的值
+func decodeValue(data interface{}) (string, error) {
+	if data == nil {
+		return "", fmt.Errorf("值为 nil")
+	}
+
+	// readResponse 已按方言把键和值解码为字符串
+	if str, ok := data.(string); ok {
+		return str, nil
+	}
+
+	return "", fmt.Errorf("不支持的值类型: %T", data)
+}
+
+// ErrInvalidValue 值未通过列族的校验, 请求未发送
+var ErrInvalidValue = errors.New("值无效")
+
+// PutOption Put 调用选项
+type PutOption func(*putOptions)
+
+type putOptions struct {
+	skipValidation bool
+}
+
+// WithoutValidation 跳过列族的值校验, 用于有意写入原始数据
+func WithoutValidation() PutOption {
+	return func(o *putOptions) {
+		o.skipValidation = true
+	}
+}
+
+// JSONValidator 返回检查值是否为合法 JSON 的校验函数
+//
+// topLevel 为 "object" 或 "array" 时还要求顶层为对应类型, 为空时接受任意 JSON 值.
+func JSONValidator(topLevel string) func([]byte) error {
+	return func(value []byte) error {
+		var raw json.RawMessage
+		if err := json.Unmarshal(value, &raw); err != nil {
+			var syntaxErr *json.SyntaxError
+			if errors.As(err, &syntaxErr) {
+				return fmt.Errorf("JSON 语法错误 (偏移 %d): %v", syntaxErr.Offset, syntaxErr)
+			}
+			return fmt.Errorf("JSON 解析失败: %v", err)
+		}
+
+		switch topLevel {
+		case "":
+		case "object":
+			if raw[0] != '{' {
+				return fmt.Errorf("顶层必须是 JSON 对象")
+			}
+		case "array":
+			if raw[0] != '[' {
+				return fmt.Errorf("顶层必须是 JSON 数组")
+			}
+		default:
+			return fmt.Errorf("未知的顶层类型要求: %s", topLevel)
+		}
+
+		return nil
+	}
+}
+
+// validateValue 按列族的校验函数检查值
+func (c *Client) validateValue(cf string, key, value []byte) error {
+	validate, ok := c.validators[cf]
+	if !ok {
+		return nil
+	}
+	err := callUser("值校验函数", func() error { return validate(value) })
+	if errors.Is(err, ErrCallbackPanic) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("%w: 列族 %s 键 %s: %v", ErrInvalidValue, cf, formatKey(key), err)
+	}
+	return nil
+}
+
+// Put 存储键值对
+func (c *Client) Put(cf, key, value string, opts ...PutOption) error {
+	return c.PutContext(context.Background(), cf, key, value, opts...)
+}
+
+// PutContext 与 Put 相同, ctx 已结束时不发送; ctx 中的请求标签 (见 WithRequestLabel) 记入错误历史
+func (c *Client) PutContext(ctx context.Context, cf, key, value string, opts ...PutOption) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cf, err := c.resolveCF(cf)
+	if err != nil {
+		return err
+	}
+
+	var o putOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	cmd := Command{
+		Type:  "Put",
+		CF:    cf,
+		Key:   []byte(key),   // 直接转字节, 按方言编码
+		Value: []byte(value), // 直接转字节
+	}
+	cmd.fromContext(ctx)
+
+	if !o.skipValidation {
+		if err := c.validateValue(cf, cmd.Key, cmd.Value); err != nil {
+			return err
+		}
+	}
+
+	if c.sizeWatcher != nil {
+		c.sizeWatcher.check(cf, cmd.Key, len(cmd.Value))
+	}
+
+	// 先用估算值拒绝明显过大的命令, 避免签名和序列化大值; 精确检查在 sendCommand
+	if size := EstimateCommandSize(cmd, c.dialect); size > maxFrameSize {
+		return fmt.Errorf("%w: Put 命令约 %d 字节, 超过单帧上限 %d", ErrInvalidArgument, size, maxFrameSize)
+	}
+
+	resp, err := c.roundTrip(cmd)
+	if err != nil {
+		return err
+	}
+
+	if resp.Error != "" {
+		return fmt.Errorf("Put 失败: %s", resp.Error)
+	}
+
+	return nil
+}
+
+// Get 获取值
+func (c *Client) Get(cf, key string) (string, bool, error) {
+	return c.GetContext(context.Background(), cf, key)
+}
+
+// GetContext 与 Get 相同, ctx 的用法见 PutContext
+func (c *Client) GetContext(ctx context.Context, cf, key string) (string, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return "", false, err
+	}
+	cf, err := c.resolveCF(cf)
+	if err != nil {
+		return "", false, err
+	}
+
+	cmd := Command{
+		Type: "Get",
+		CF:   cf,
+		Key:  []byte(key),
+	}
+	cmd.fromContext(ctx)
+
+	resp, err := c.roundTrip(cmd)
+	if err != nil {
+		return "", false, err
+	}
+
+	if resp.Error != "" {
+		return "", false, fmt.Errorf("Get 失败: %s", resp.Error)
+	}
+
+	// 检查是否找到值
+	if resp.Value == nil {
+		return "", false, nil
+	}
+
+	// 解码值
+	value, err := decodeValue(resp.Value)
+	if err != nil {
+		return "", false, fmt.Errorf("解码值失败: %w", err)
+	}
+
+	return value, true, nil
+}
+
+// Delete 删除键
+func (c *Client) Delete(cf, key string) error {
+	return c.DeleteContext(context.Background(), cf, key)
+}
+
+// DeleteContext 与 Delete 相同, ctx 的用法见 PutContext
+func (c *Client) DeleteContext(ctx context.Context, cf, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	cf, err := c.resolveCF(cf)
+	if err != nil {
+		return err
+	}
+
+	cmd := Command{
+		Type: "Delete",
+		CF:   cf,
+		Key:  []byte(key),
+	}
+	cmd.fromContext(ctx)
+
+	resp, err := c.roundTrip(cmd)
+	if err != nil {
+		return err
+	}
+
+	if resp.Error != "" {
+		return fmt.Errorf("Delete 失败: %s", resp.Error)
+	}
+
+	return nil
+}
+
+// defaultDeletePageSize DeleteRange 每页扫描的键数, 响应超过单帧时自动减小
+const defaultDeletePageSize = 100
+
+// DeleteRangeProgress DeleteRange 的进度
+type DeleteRangeProgress struct {
+	Deleted    int // 试运行时为将要删除的数量
+	CurrentKey string
+	Elapsed    time.Duration
+}
+
+// DeleteRangeResult DeleteRange 的结果
+type DeleteRangeResult struct {
+	Deleted int // 试运行时为将要删除的数量
+	// Cursor 中断时下一个待处理的键, 以它为起点再次调用即可继续; 完成时为空
+	Cursor string
+}
+
+// DeleteRangeOption DeleteRange 调用选项
+type DeleteRangeOption func(*deleteRangeOptions)
+
+type deleteRangeOptions struct {
+	pageSize     int
+	progress     func(DeleteRangeProgress)
+	dryRun       bool
+	unsafe       bool
+	confirmation string
+}
+
+// WithDeletePageSize 设置每页扫描的键数
+func WithDeletePageSize(n int) DeleteRangeOption {
+	return func(o *deleteRangeOptions) {
+		o.pageSize = n
+	}
+}
+
+// WithDeleteProgress 每处理完一页调用一次 fn; fn panic 时 DeleteRange 返回 ErrCallbackPanic,
+// Cursor 指向下一页
+func WithDeleteProgress(fn func(DeleteRangeProgress)) DeleteRangeOption {
+	return func(o *deleteRangeOptions) {
+		o.progress = fn
+	}
+}
+
+// WithDeleteDryRun 只统计将要删除的数量, 不删除
+func WithDeleteDryRun() DeleteRangeOption {
+	return func(o *deleteRangeOptions) {
+		o.dryRun = true
+	}
+}
+
+// ErrConfirmationRequired 删除整个列族时没有提供确认, 或确认与列族不符
+var ErrConfirmationRequired = errors.New("删除整个列族需要确认")
+
+// ConfirmationToken 返回删除整个列族 cf 时需要传给 WithConfirmation 的确认串
+func ConfirmationToken(cf string) string {
+	return "delete-all:" + cf
+}
+
+// WithConfirmation 确认删除整个列族, token 必须等于 ConfirmationToken(cf)
+//
+// 确认串应当写成字面量而不是由同一个变量计算, 这样列族变量传错时删除会被拒绝.
+func WithConfirmation(token string) DeleteRangeOption {
+	return func(o *deleteRangeOptions) {
+		o.confirmation = token
+	}
+}
+
+// WithUnsafeWholeCF 允许不经确认删除整个列族, 用于测试和一次性脚本
+func WithUnsafeWholeCF() DeleteRangeOption {
+	return func(o *deleteRangeOptions) {
+		o.unsafe = true
+	}
+}
+
+// DeleteRange 删除 [startKey, endKey) 范围内的键, endKey 为 nil 表示到列族末尾
+//
+// 服务器没有范围删除命令, 因此按页扫描后逐个删除. 每个请求前检查 ctx, 被取消或
+// 删除失败时返回已删除的数量和可以继续的 Cursor. Scan 上限小于页大小时每页按
+// 上限收紧 (见 ScanPage), 范围仍会删完; 无法分页时同样返回 Cursor 和错误.
+func (c *Client) DeleteRange(ctx context.Context, cf, startKey string, endKey *string, opts ...DeleteRangeOption) (*DeleteRangeResult, error) {
+	cf, err := c.resolveCF(cf)
+	if err != nil {
+		return nil, err
+	}
+
+	o := deleteRangeOptions{pageSize: defaultDeletePageSize}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if startKey == "" && endKey == nil && !o.dryRun {
+		if err := c.confirmWholeCF(cf, o); err != nil {
+			return nil, err
+		}
+	}
+
+	start := c.clock.Now()
+	result := &DeleteRangeResult{}
+	cursor := startKey
+	for {
+		if err := ctx.Err(); err != nil {
+			result.Cursor = cursor
+			return result, err
+		}
+
+		page, err := c.scanPageShrinking(ctx, cf, cursor, endKey, &o.pageSize)
+		if err != nil {
+			result.Cursor = cursor
+			return result, err
+		}
+
+		for _, item := range page.Items {
+			if !o.dryRun {
+				if err := c.DeleteContext(ctx, cf, item.Key); err != nil {
+					result.Cursor = item.Key
+					return result, err
+				}
+			}
+			result.Deleted++
+		}
+
+		if o.progress != nil && len(page.Items) > 0 {
+			progress := DeleteRangeProgress{
+				Deleted:    result.Deleted,
+				CurrentKey: page.Items[len(page.Items)-1].Key,
+				Elapsed:    c.clock.Now().Sub(start),
+			}
+			if err := callUser("DeleteRange 进度回调", func() error { o.progress(progress); return nil }); err != nil {
+				// 本页已处理完, 从下一页继续
+				if page.HasMore {
+					result.Cursor = page.NextCursor
+				}
+				return result, err
+			}
+		}
+
+		if !page.HasMore {
+			return result, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// scanPageShrinking 与 scanPage 相同, 但响应超过单帧 (ErrFrame) 时把 *limit 减半后重试
+//
+// 一页能容纳多少条取决于值的大小, 无法事先确定; 减小后的 *limit 留给之后的页使用.
+// 每次重试在错误历史中记为同一操作的下一次尝试.
+func (c *Client) scanPageShrinking(ctx context.Context, cf, startKey string, endKey *string, limit *int) (*ScanPage, error) {
+	for attempt := 1; ; attempt++ {
+		page, err := c.scanPage(withAttempt(ctx, attempt), cf, startKey, endKey, *limit)
+		if err == nil || !errors.Is(err, ErrFrame) || *limit <= 1 {
+			return page, err
+		}
+		*limit /= 2
+	}
+}
+
+// MigrateEmptyCF 将误写入名为 "" 的列族的数据复制到 target, 返回复制的键数
+//
+// 部分服务器版本会把空列族参数当作名为 "" 的列族创建. 服务器的 Info 中没有该列族时
+// 直接返回. 只复制不删除, 确认无误后再由调用方清理; target 为空时使用默认列族.
+func (c *Client) MigrateEmptyCF(ctx context.Context, target string) (int, error) {
+	target, err := c.resolveCF(target)
+	if err != nil {
+		return 0, err
+	}
+
+	info, err := c.InfoDetailed(ForceRefresh())
+	if err != nil {
+		return 0, err
+	}
+	found := false
+	for _, cf := range info.ColumnFamilies {
+		if cf == "" {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return 0, nil
+	}
+
+	copied := 0
+	cursor := ""
+	pageSize := defaultDeletePageSize
+	for {
+		if err := ctx.Err(); err != nil {
+			return copied, err
+		}
+
+		page, err := c.scanPageShrinking(ctx, "", cursor, nil, &pageSize)
+		if err != nil {
+			return copied, err
+		}
+		for _, item := range page.Items {
+			if err := c.PutContext(ctx, target, item.Key, item.Value); err != nil {
+				return copied, fmt.Errorf("复制键 %s 失败: %w", item.Key, err)
+			}
+			copied++
+		}
+
+		if !page.HasMore {
+			return copied, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// confirmWholeCF 检查删除整个列族的确认, 通过时写一条审计日志
+func (c *Client) confirmWholeCF(cf string, o deleteRangeOptions) error {
+	var how string
+	switch {
+	case o.confirmation == ConfirmationToken(cf):
+		how = "确认串"
+	case o.confirmation != "":
+		return fmt.Errorf("%w: 列族 %s 的确认串应为 %q, 实际为 %q", ErrConfirmationRequired, cf, ConfirmationToken(cf), o.confirmation)
+	case o.unsafe:
+		how = "WithUnsafeWholeCF"
+	default:
+		return fmt.Errorf("%w: 列族 %s, 使用 WithConfirmation(%q)", ErrConfirmationRequired, cf, ConfirmationToken(cf))
+	}
+
+	fmt.Fprintf(os.Stderr, "%s 删除整个列族 %s, 地址 %s, 确认方式 %s\n", c.auditTag(), cf, c.addr, how)
+	return nil
+}
+
+// ErrInvalidArgument 参数不合法, 请求未发送
+var ErrInvalidArgument = errors.New("参数无效")
+
+// resolveCF 将空列族参数映射到默认列族, 严格模式下报错
+func (c *Client) resolveCF(cf string) (string, error) {
+	if cf != "" {
+		return cf, nil
+	}
+	if c.strictCF {
+		return "", fmt.Errorf("%w: 列族不能为空", ErrInvalidArgument)
+	}
+	return c.defaultCF, nil
+}
+
+// effectiveScanLimit 按客户端配置确定实际发送的 limit, 0 表示不限制
+func (c *Client) effectiveScanLimit(limit int) (int, error) {
+	if limit < 0 {
+		return 0, fmt.Errorf("%w: Scan limit 不能为负数: %d", ErrInvalidArgument, limit)
+	}
+	if limit == 0 {
+		limit = c.defaultScanLimit
+	}
+	if c.maxScanLimit > 0 && (limit == 0 || limit > c.maxScanLimit) {
+		if c.strictScanLimit {
+			return 0, fmt.Errorf("%w: Scan limit %d 超过上限 %d (0 表示不限制)", ErrInvalidArgument, limit, c.maxScanLimit)
+		}
+		limit = c.maxScanLimit
+	}
+	return limit, nil
+}
+
+// pageLimit 确定分页扫描每页的条数, 为探测下一页的一条保留上限内的位置
+func (c *Client) pageLimit(limit int) (int, error) {
+	limit, err := c.effectiveScanLimit(limit)
+	if err != nil {
+		return 0, err
+	}
+	if c.strictScanLimit && c.maxScanLimit > 0 && limit >= c.maxScanLimit {
+		return 0, fmt.Errorf("%w: 分页扫描需要多读一条, limit %d 加一后超过上限 %d", ErrInvalidArgument, limit, c.maxScanLimit)
+	}
+
+	maxLimit := c.controls.maxScanLimit()
+	if c.maxScanLimit > 0 && (maxLimit == 0 || c.maxScanLimit < maxLimit) {
+		maxLimit = c.maxScanLimit
+	}
+	if maxLimit > 0 && limit >= maxLimit {
+		if maxLimit < 2 {
+			return 0, fmt.Errorf("%w: Scan 上限 %d 不足以分页 (每页至少一条加一条探测)", ErrInvalidArgument, maxLimit)
+		}
+		limit = maxLimit - 1
+	}
+	return limit, nil
+}
+
+// Scan 扫描范围, 结果是以 "key" 和 "value" 为键的 map
+//
+// Deprecated: 使用 ScanItems.
+func (c *Client) Scan(cf, startKey string, endKey *string, limit int) ([]map[string]string, error) {
+	if err := c.warnDeprecated("Client.Scan", "Client.ScanItems"); err != nil {
+		return nil, err
+	}
+	items, err := c.ScanItems(cf, startKey, endKey, limit)
+	return scanItemsToMaps(items), err
+}
+
+// scanItemsToMaps 转换为 Scan 的旧格式
+func scanItemsToMaps(items []ScanItem) []map[string]string {
+	if items == nil {
+		return nil
+	}
+	result := make([]map[string]string, 0, len(items))
+	for _, item := range items {
+		result = append(result, map[string]string{"key": item.Key, "value": item.Value})
+	}
+	return result
+}
+
+// ScanItems 扫描范围, limit 为 0 表示不限制, 负数返回 ErrInvalidArgument
+func (c *Client) ScanItems(cf, startKey string, endKey *string, limit int) ([]ScanItem, error) {
+	return c.ScanItemsContext(context.Background(), cf, startKey, endKey, limit)
+}
+
+// ScanItemsContext 与 ScanItems 相同, ctx 的用法见 PutContext
+func (c *Client) ScanItemsContext(ctx context.Context, cf, startKey string, endKey *string, limit int) ([]ScanItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	cf, err := c.resolveCF(cf)
+	if err != nil {
+		return nil, err
+	}
+	limit, err = c.effectiveScanLimit(limit)
+	if err != nil {
+		return nil, err
+	}
+	return c.scan(ctx, cf, startKey, endKey, limit, false)
+}
+
+// scan 按给定的 limit 发送 Scan 命令
+func (c *Client) scan(ctx context.Context, cf, startKey string, endKey *string, limit int, exact bool) ([]ScanItem, error) {
+	cmd := Command{
+		Type:       "Scan",
+		CF:         cf,
+		StartKey:   []byte(startKey),
+		Limit:      &limit,
+		exactLimit: exact,
+	}
+	cmd.fromContext(ctx)
+
+	if endKey != nil {
+		endKeyBytes := []byte(*endKey)
+		cmd.EndKey = &endKeyBytes
+	}
+
+	resp, err := c.roundTrip(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != "" && resp.Values == nil {
+		return nil, fmt.Errorf("Scan 失败: %s", resp.Error)
+	}
+
+	// 解析结果 [[key, value], ...]
+	var result []ScanItem
+	if resp.Values != nil {
+		valuesArr, ok := resp.Values.([]interface{})
+		if !ok {
+			if resp.Error != "" {
+				return nil, fmt.Errorf("Scan 失败: %s", resp.Error)
+			}
+			return nil, fmt.Errorf("Scan 响应格式错误")
+		}
+
+		for _, item := range valuesArr {
+			itemArr, ok := item.([]interface{})
+			if !ok || len(itemArr) != 2 {
+				continue
+			}
+
+			// 解码 key 和 value
+			key, err := decodeValue(itemArr[0])
+			if err != nil {
+				continue
+			}
+
+			value, err := decodeValue(itemArr[1])
+			if err != nil {
+				continue
+			}
+
+			result = append(result, ScanItem{Key: key, Value: value})
+		}
+	}
+
+	// 部分服务器在扫描中途出错时同时返回错误和已读到的数据
+	if resp.Error != "" {
+		return nil, &PartialResultError{Op: "Scan", Message: resp.Error, Items: result}
+	}
+
+	return result, nil
+}
+
+// ScanItem 扫描结果中的一个键值对
+type ScanItem struct {
+	Key   string
+	Value string
+}
+
+// PartialResultError 服务器同时返回了错误和部分结果
+//
+// Items 是出错前成功解码的数据, 按键有序; 需要完整结果的调用方应当作普通错误
+// 处理, 可以接受部分结果的调用方 (如导出) 可以使用 Items 并从最后一个键之后继续.
+type PartialResultError struct {
+	Op      string
+	Message string // 服务器返回的错误
+	Items   []ScanItem
+}
+
+func (e *PartialResultError) Error() string {
+	return fmt.Sprintf("%s 失败 (已返回 %d 条部分结果): %s", e.Op, len(e.Items), e.Message)
+}
+
+// ScanPage 一页扫描结果
+type ScanPage struct {
+	Items []ScanItem
+	// HasMore 为 true 表示范围内还有更多数据, 下一页从 NextCursor (包含) 开始
+	HasMore    bool
+	NextCursor string
+}
+
+// ScanPage 扫描一页数据并判断范围是否已经读完
+//
+// 服务器不返回是否截断, 因此客户端多请求一条: 多出的那条存在即说明还有数据,
+// 它的键就是下一页的起点, 翻页时不需要额外的空请求. 多请求的一条也受 Scan 上限
+// 约束: WithMaxScanLimit 或 Controls.SetMaxScanLimit 为 n 时每页最多 n-1 条,
+// 严格模式下 limit 达到 n 返回 ErrInvalidArgument.
+func (c *Client) ScanPage(cf, startKey string, endKey *string, limit int) (*ScanPage, error) {
+	cf, err := c.resolveCF(cf)
+	if err != nil {
+		return nil, err
+	}
+	return c.scanPage(context.Background(), cf, startKey, endKey, limit)
+}
+
+// scanPage 与 ScanPage 相同, 但列族按原样发送
+func (c *Client) scanPage(ctx context.Context, cf, startKey string, endKey *string, limit int) (*ScanPage, error) {
+	if limit <= 0 {
+		return nil, fmt.Errorf("%w: 分页扫描的 limit 必须大于 0: %d", ErrInvalidArgument, limit)
+	}
+	limit, err := c.pageLimit(limit)
+	if err != nil {
+		return nil, err
+	}
+
+	// 发送的 limit 已在上限之内, 不允许运行时控制再收紧, 否则被截断的页会被误判为最后一页
+	items, err := c.scan(ctx, cf, startKey, endKey, limit+1, true)
+	if err != nil {
+		var partial *PartialResultError
+		if errors.As(err, &partial) && len(partial.Items) > limit {
+			partial.Items = partial.Items[:limit]
+		}
+		return nil, err
+	}
+
+	page := &ScanPage{Items: items}
+	if len(page.Items) > limit {
+		page.HasMore = true
+		page.NextCursor = page.Items[limit].Key
+		page.Items = page.Items[:limit]
+	}
+
+	return page, nil
+}
+
+// ScanProject 扫描范围, 每个值只保留指定的 JSON 路径
+//
+// 服务器不支持投影, 由客户端逐个解析值并提取字段, 带宽不会减少, 但调用方拿到的
+// 数据只包含所需字段. 每个结果是以路径为键的 JSON 对象, 键顺序与 paths 一致,
+// 缺失的路径为 null. 路径以 "." 分隔, 数组元素用下标访问, 如 "items.0.id".
+func (c *Client) ScanProject(cf, startKey string, endKey *string, limit int, paths []string) ([][]byte, error) {
+	items, err := c.ScanItems(cf, startKey, endKey, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([][]byte, 0, len(items))
+	for _, item := range items {
+		projected, err := projectJSON([]byte(item.Value), paths)
+		if err != nil {
+			return nil, fmt.Errorf("投影键 %s 失败: %w", item.Key, err)
+		}
+		result = append(result, projected)
+	}
+
+	return result, nil
+}
+
+// projectJSON 从 JSON 文档中提取 paths, 组成新的 JSON 对象
+func projectJSON(doc []byte, paths []string) ([]byte, error) {
+	dec := json.NewDecoder(bytes.NewReader(doc))
+	dec.UseNumber() // 保持数字原样, 避免 float64 精度损失
+	var root interface{}
+	if err := dec.Decode(&root); err != nil {
+		return nil, fmt.Errorf("值不是合法 JSON: %w", err)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, path := range paths {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		name, err := json.Marshal(path)
+		if err != nil {
+			return nil, err
+		}
+		field, err := json.Marshal(lookupJSONPath(root, path))
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.Write(field)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// lookupJSONPath 按路径查找字段, 不存在时返回 nil
+func lookupJSONPath(node interface{}, path string) interface{} {
+	for _, part := range strings.Split(path, ".") {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			node = v[part]
+		case []interface{}:
+			idx, err := strconv.Atoi(part)
+			if err != nil || idx < 0 || idx >= len(v) {
+				return nil
+			}
+			node = v[idx]
+		default:
+			return nil
+		}
+	}
+	return node
+}
+
+// InfoResult 服务器信息
+type InfoResult struct {
+	TotalKeys      int
+	ColumnFamilies []string
+
+	// Stale 为 true 表示 Info 超时, 这是缓存中最后一次成功的结果, Age 为其已存在的时间
+	Stale bool
+	Age   time.Duration
+}
+
+// clone 复制结果, 避免调用方修改缓存中的切片
+func (r *InfoResult) clone() *InfoResult {
+	cp := *r
+	cp.ColumnFamilies = append([]string(nil), r.ColumnFamilies...)
+	return &cp
+}
+
+// metadataCache Info 结果缓存
+type metadataCache struct {
+	mu         sync.Mutex // 刷新期间持有, 并发调用只触发一次 Info
+	ttl        time.Duration
+	clock      Clock
+	info       *InfoResult // 失效后仍保留, 供超时回退使用
+	fetchedAt  time.Time
+	fetchedGen uint64
+
+	gen atomic.Uint64 // invalidate 时递增, 不需要持有 mu, 刷新过程中也可调用
+}
+
+// get 返回未过期的缓存结果, 过期或强制刷新时调用 fetch
+//
+// staleFallback 为 true 且 fetch 超时时, 返回上一次成功的结果并标记为 Stale.
+func (m *metadataCache) get(force, staleFallback bool, fetch func() (*InfoResult, error)) (*InfoResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	gen := m.gen.Load()
+	if !force && m.info != nil && m.fetchedGen == gen && m.clock.Now().Sub(m.fetchedAt) < m.ttl {
+		return m.info.clone(), nil
+	}
+
+	info, err := fetch()
+	if err != nil {
+		if staleFallback && m.info != nil && isTimeout(err) {
+			stale := m.info.clone()
+			stale.Stale = true
+			stale.Age = m.clock.Now().Sub(m.fetchedAt)
+			return stale, nil
+		}
+		return nil, err
+	}
+	m.info = info
+	m.fetchedAt = m.clock.Now()
+	m.fetchedGen = gen
+	return info.clone(), nil
+}
+
+// invalidate 使缓存失效
+func (m *metadataCache) invalidate() {
+	m.gen.Add(1)
+}
+
+// InfoOption Info 调用选项
+type InfoOption func(*infoOptions)
+
+type infoOptions struct {
+	forceRefresh bool
+}
+
+// ForceRefresh 跳过缓存, 直接从服务器获取
+func ForceRefresh() InfoOption {
+	return func(o *infoOptions) {
+		o.forceRefresh = true
+	}
+}
+
+// InvalidateMetadataCache 使 Info 缓存失效, 在带外修改列族后调用
+func (c *Client) InvalidateMetadataCache() {
+	if c.metaCache != nil {
+		c.metaCache.invalidate()
+	}
+}
+
+// Info 获取服务器的键总数和列族列表
+//
+// Deprecated: 使用 InfoDetailed, 它同时返回结果是否过期.
+func (c *Client) Info(opts ...InfoOption) (int, []string, error) {
+	if err := c.warnDeprecated("Client.Info", "Client.InfoDetailed"); err != nil {
+		return 0, nil, err
+	}
+	info, err := c.InfoDetailed(opts...)
+	if err != nil {
+		return 0, nil, err
+	}
+	return info.TotalKeys, info.ColumnFamilies, nil
+}
+
+// InfoDetailed 获取服务器信息, 结果在缓存有效期内复用
+func (c *Client) InfoDetailed(opts ...InfoOption) (*InfoResult, error) {
+	var o infoOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	if c.metaCache == nil {
+		return c.fetchInfo()
+	}
+	return c.metaCache.get(o.forceRefresh, c.staleInfoFallback, c.fetchInfo)
+}
+
+// fetchInfo 向服务器发送 Info 命令
+func (c *Client) fetchInfo() (*InfoResult, error) {
+	cmd := Command{
+		Type: "Info",
+	}
+
+	resp, err := c.roundTripTimeout(cmd, c.infoTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.Error != "" {
+		return nil, fmt.Errorf("Info 失败: %s", resp.Error)
+	}
+
+	if resp.Info == nil {
+		return nil, fmt.Errorf("Info 响应为空")
+	}
+
+	info := &InfoResult{}
+	if tk, ok := resp.Info["total_keys"].(float64); ok {
+		info.TotalKeys = int(tk)
+	}
+
+	if cfList, ok := resp.Info["column_families"].([]interface{}); ok {
+		for _, cf := range cfList {
+			if cfStr, ok := cf.(string); ok {
+				info.ColumnFamilies = append(info.ColumnFamilies, cfStr)
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// Flush 刷盘
+func (c *Client) Flush() error {
+	cmd := Command{
+		Type: "Flush",
+	}
+
+	resp, err := c.roundTrip(cmd)
+	if err != nil {
+		return err
+	}
+
+	if resp.Error != "" {
+		return fmt.Errorf("Flush 失败: %s", resp.Error)
+	}
+
+	return nil
+}
+
+// InfoChange 单个 Info 字段的变化
+type InfoChange struct {
+	Field string
+	Old   interface{}
+	New   interface{}
+}
+
+// InfoDelta 两次 Info 采样之间的变化
+type InfoDelta struct {
+	At      time.Time
+	Changes []InfoChange
+	Err     error // 非 nil 表示订阅已结束, 这是通道关闭前的最后一个元素
+}
+
+// Change 返回指定字段的变化
+func (d InfoDelta) Change(field string) (InfoChange, bool) {
+	for _, ch := range d.Changes {
+		if ch.Field == field {
+			return ch, true
+		}
+	}
+	return InfoChange{}, false
+}
+
+// diffInfo 比较两次采样, 返回发生变化的字段
+func diffInfo(old, cur *InfoResult) []InfoChange {
+	var changes []InfoChange
+	if old.TotalKeys != cur.TotalKeys {
+		changes = append(changes, InfoChange{Field: "total_keys", Old: old.TotalKeys, New: cur.TotalKeys})
+	}
+	if !equalStrings(old.ColumnFamilies, cur.ColumnFamilies) {
+		changes = append(changes, InfoChange{Field: "column_families", Old: old.ColumnFamilies, New: cur.ColumnFamilies})
+	}
+	return changes
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// PollInfo 按间隔采样 Info, 只在字段变化时发送 InfoDelta
+//
+// 采样经过 Info 缓存, 多个订阅者不会成倍增加服务器负载. 单次采样失败或过期时跳过,
+// 保留上一次的结果作为基准. ctx 结束或客户端关闭后发送一个 Err 非空 (ctx.Err() 或
+// ErrClosed) 的 InfoDelta 并关闭通道, 若调用方尚未读取之前的变化, 该变化会被丢弃以
+// 保证结束原因可以送达.
+func (c *Client) PollInfo(ctx context.Context, interval time.Duration) (<-chan InfoDelta, error) {
+	if interval <= 0 {
+		return nil, fmt.Errorf("采样间隔必须大于 0: %v", interval)
+	}
+
+	ch := make(chan InfoDelta, 1)
+	// finish 送出结束原因, 必要时丢弃调用方尚未读取的变化
+	finish := func(err error) {
+		final := InfoDelta{At: c.clock.Now(), Err: err}
+		for {
+			select {
+			case ch <- final:
+				return
+			default:
+			}
+			select {
+			case <-ch:
+			default:
+			}
+		}
+	}
+
+	go func() {
+		defer close(ch)
+
+		var last *InfoResult
+		for {
+			timer := c.clock.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				finish(ctx.Err())
+				return
+			case <-c.done.Done():
+				timer.Stop()
+				finish(ErrClosed)
+				return
+			case <-timer.C():
+			}
+
+			info, err := c.InfoDetailed()
+			if errors.Is(err, ErrClosed) {
+				finish(ErrClosed)
+				return
+			}
+			if err != nil || info.Stale {
+				continue
+			}
+			if last == nil {
+				last = info
+				continue
+			}
+
+			changes := diffInfo(last, info)
+			last = info
+			if len(changes) == 0 {
+				continue
+			}
+
+			select {
+			case ch <- InfoDelta{At: c.clock.Now(), Changes: changes}:
+			case <-ctx.Done():
+			case <-c.done.Done():
+			}
+		}
+	}()
+
+	return ch, nil
+}
+
+// WatchThreshold 每隔 interval 采样, 在数值字段越过阈值 (任一方向) 时调用 fn,
+// 直到 ctx 结束或客户端关闭
+//
+// 目前只有 total_keys 是数值字段. fn 中的 panic 被捕获并输出到标准错误, 不影响之后的回调.
+func (c *Client) WatchThreshold(ctx context.Context, field string, threshold float64, interval time.Duration, fn func(InfoDelta)) error {
+	if field != "total_keys" {
+		return fmt.Errorf("不支持的数值字段: %s", field)
+	}
+
+	deltas, err := c.PollInfo(ctx, interval)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		for delta := range deltas {
+			change, ok := delta.Change(field)
+			if !ok {
+				continue
+			}
+			old := float64(change.Old.(int))
+			cur := float64(change.New.(int))
+			if (old < threshold) != (cur < threshold) {
+				c.callThresholdFn(fn, delta)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// callThresholdFn 调用 WatchThreshold 的回调, 其中的 panic 输出到标准错误
+func (c *Client) callThresholdFn(fn func(InfoDelta), delta InfoDelta) {
+	if err := callUser("WatchThreshold 回调", func() error { fn(delta); return nil }); err != nil {
+		fmt.Fprintf(os.Stderr, "%s %v\n", c.debugTag(), err)
+	}
+}
+
+// ErrCallbackPanic 用户提供的回调 (校验函数, 签名器, 进度回调等) 发生了 panic
+var ErrCallbackPanic = errors.New("回调 panic")
+
+// callUser 调用用户提供的回调, 将其中的 panic 转换为带调用栈的 ErrCallbackPanic
+//
+// 回调都在持有连接之前或两次往返之间调用, panic 不会使连接停在一帧的中间;
+// doRoundTrip 以 defer 释放 c.mu, 等待者不会被卡住.
+func callUser(name string, fn func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%w: %s: %v\n%s", ErrCallbackPanic, name, r, debug.Stack())
+		}
+	}()
+	return fn()
+}
+
+// ErrBadSignature 签名校验失败
+var ErrBadSignature = errors.New("签名无效")
+
+// mutatingCommands 会修改数据的命令类型
+var mutatingCommands = map[string]bool{
+	"Put":    true,
+	"Delete": true,
+}
+
+// Signature 命令签名, KeyID 用于密钥轮换时选择校验密钥
+type Signature struct {
+	KeyID string `json:"key_id"`
+	Alg   string `json:"alg"`
+	Sig   []byte `json:"sig"`
+}
+
+// Signer 对命令的规范化序列化结果签名
+type Signer interface {
+	Sign(payload []byte) (*Signature, error)
+}
+
+// Verifier 校验 Signer 产生的签名
+type Verifier interface {
+	Verify(payload []byte, sig *Signature) error
+}
+
+// HMACSigner 使用 HMAC-SHA256 签名
+type HMACSigner struct {
+	KeyID string
+	Key   []byte
+}
+
+// Sign 实现 Signer
+func (s HMACSigner) Sign(payload []byte) (*Signature, error) {
+	mac := hmac.New(sha256.New, s.Key)
+	mac.Write(payload)
+	return &Signature{KeyID: s.KeyID, Alg: "HS256", Sig: mac.Sum(nil)}, nil
+}
+
+// Ed25519Signer 使用 ed25519 签名
+type Ed25519Signer struct {
+	KeyID string
+	Key   ed25519.PrivateKey
+}
+
+// Sign 实现 Signer
+func (s Ed25519Signer) Sign(payload []byte) (*Signature, error) {
+	return &Signature{KeyID: s.KeyID, Alg: "Ed25519", Sig: ed25519.Sign(s.Key, payload)}, nil
+}
+
+// KeyVerifier 按 KeyID 查找密钥校验签名, 轮换期间新旧密钥可以同时登记
+type KeyVerifier struct {
+	HMACKeys    map[string][]byte
+	Ed25519Keys map[string]ed25519.PublicKey
+}
+
+// Verify 实现 Verifier
+func (v KeyVerifier) Verify(payload []byte, sig *Signature) error {
+	if sig == nil {
+		return fmt.Errorf("%w: 缺少签名", ErrBadSignature)
+	}
+
+	switch sig.Alg {
+	case "HS256":
+		key, ok := v.HMACKeys[sig.KeyID]
+		if !ok {
+			return fmt.Errorf("%w: 未知密钥 %s", ErrBadSignature, sig.KeyID)
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write(payload)
+		if !hmac.Equal(mac.Sum(nil), sig.Sig) {
+			return fmt.Errorf("%w: 密钥 %s 校验不通过", ErrBadSignature, sig.KeyID)
+		}
+	case "Ed25519":
+		key, ok := v.Ed25519Keys[sig.KeyID]
+		if !ok {
+			return fmt.Errorf("%w: 未知密钥 %s", ErrBadSignature, sig.KeyID)
+		}
+		if !ed25519.Verify(key, payload, sig.Sig) {
+			return fmt.Errorf("%w: 密钥 %s 校验不通过", ErrBadSignature, sig.KeyID)
+		}
+	default:
+		return fmt.Errorf("%w: 不支持的算法 %s", ErrBadSignature, sig.Alg)
+	}
+
+	return nil
+}
+
+// signCommand 为修改类命令附加签名
+func signCommand(s Signer, cmd *Command) error {
+	if !mutatingCommands[cmd.Type] {
+		return nil
+	}
+
+	payload, err := CanonicalCommand(*cmd)
+	if err != nil {
+		return err
+	}
+	sig, err := s.Sign(payload)
+	if err != nil {
+		return fmt.Errorf("签名命令失败: %w", err)
+	}
+	cmd.Signature = sig
+
+	return nil
+}
+
+// VerifyCommand 校验记录下来的命令的签名
+func VerifyCommand(v Verifier, cmd Command) error {
+	payload, err := CanonicalCommand(cmd)
+	if err != nil {
+		return err
+	}
+	return v.Verify(payload, cmd.Signature)
+}
+
+// CanonicalCommand 返回命令的规范化 JSON, 不含 Signature 字段
+//
+// 对象的键按字节序排列, 不含多余空白, 字符串不做 HTML 转义, 数字保持原样,
+// 因此结果与结构体字段的声明顺序以及 encoding/json 的 map 输出顺序无关.
+func CanonicalCommand(cmd Command) ([]byte, error) {
+	cmd.Signature = nil
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("序列化命令失败: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, fmt.Errorf("序列化命令失败: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeCanonicalJSON(&buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeCanonicalJSON 按规范格式写出 JSON 值
+func writeCanonicalJSON(buf *bytes.Buffer, v interface{}) error {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalString(buf, k); err != nil {
+				return err
+			}
+			buf.WriteByte(':')
+			if err := writeCanonicalJSON(buf, val[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, item := range val {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonicalJSON(buf, item); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case string:
+		return writeCanonicalString(buf, val)
+	case json.Number:
+		buf.WriteString(val.String())
+	case bool:
+		buf.WriteString(strconv.FormatBool(val))
+	case nil:
+		buf.WriteString("null")
+	default:
+		return fmt.Errorf("不支持的 JSON 类型: %T", v)
+	}
+
+	return nil
+}
+
+// writeCanonicalString 写出不做 HTML 转义的 JSON 字符串
+func writeCanonicalString(buf *bytes.Buffer, s string) error {
+	var tmp bytes.Buffer
+	enc := json.NewEncoder(&tmp)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return err
+	}
+	buf.Write(bytes.TrimSuffix(tmp.Bytes(), []byte("\n")))
+	return nil
+}
+
+// ErrAdministrativelyDisabled 命令被运行时控制禁用
+var ErrAdministrativelyDisabled = errors.New("命令已被管理员禁用")
+
+// DisableReason 禁用命令的操作记录, 由管理接口提供
+type DisableReason struct {
+	By     string    `json:"by,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// ControlState 运行时控制的状态, 只保存在内存中, 进程重启后恢复默认
+type ControlState struct {
+	DisabledCommands map[string]DisableReason `json:"disabled_commands"`
+	MaxScanLimit     int                      `json:"max_scan_limit"`    // 0 表示不限制
+	GlobalRateLimit  float64                  `json:"global_rate_limit"` // 每秒操作数, 0 表示不限制
+}
+
+// Controls 客户端的运行时控制, 用于事故期间停止昂贵操作
+type Controls struct {
+	mu    sync.Mutex
+	clock Clock
+	state ControlState
+	next  time.Time // 速率限制下一个操作允许发出的时间
+
+	done context.Context // 客户端关闭时结束, 中断速率限制的等待
+}
+
+func newControls(clock Clock, done context.Context) *Controls {
+	return &Controls{
+		clock: clock,
+		state: ControlState{DisabledCommands: map[string]DisableReason{}},
+		done:  done,
+	}
+}
+
+// Controls 返回客户端的运行时控制
+func (c *Client) Controls() *Controls {
+	return c.controls
+}
+
+// DisableCommand 禁用指定类型的命令, 如 "Scan"
+func (ctl *Controls) DisableCommand(cmdType string) {
+	ctl.disable(cmdType, DisableReason{At: ctl.clock.Now()})
+}
+
+// EnableCommand 恢复指定类型的命令
+func (ctl *Controls) EnableCommand(cmdType string) {
+	ctl.mu.Lock()
+	delete(ctl.state.DisabledCommands, cmdType)
+	ctl.mu.Unlock()
+}
+
+func (ctl *Controls) disable(cmdType string, reason DisableReason) {
+	ctl.mu.Lock()
+	ctl.state.DisabledCommands[cmdType] = reason
+	ctl.mu.Unlock()
+}
+
+// SetMaxScanLimit 限制 Scan 的最大返回条数, 0 表示不限制
+func (ctl *Controls) SetMaxScanLimit(n int) {
+	ctl.mu.Lock()
+	ctl.state.MaxScanLimit = n
+	ctl.mu.Unlock()
+}
+
+func (ctl *Controls) maxScanLimit() int {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+	return ctl.state.MaxScanLimit
+}
+
+// SetGlobalRateLimit 限制每秒发出的操作数, 0 表示不限制
+func (ctl *Controls) SetGlobalRateLimit(ops float64) {
+	ctl.mu.Lock()
+	ctl.state.GlobalRateLimit = ops
+	ctl.mu.Unlock()
+}
+
+// State 返回当前控制状态的副本
+func (ctl *Controls) State() ControlState {
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+
+	st := ctl.state
+	st.DisabledCommands = make(map[string]DisableReason, len(ctl.state.DisabledCommands))
+	for k, v := range ctl.state.DisabledCommands {
+		st.DisabledCommands[k] = v
+	}
+	return st
+}
+
+// controlPatch 管理接口 POST 的请求体, 只修改出现的字段
+type controlPatch struct {
+	// DisabledCommands 中值为 null 的命令恢复, 其余被禁用; 没有列出的命令不变
+	DisabledCommands map[string]*DisableReason `json:"disabled_commands"`
+	MaxScanLimit     *int                      `json:"max_scan_limit"`
+	GlobalRateLimit  *float64                  `json:"global_rate_limit"`
+}
+
+// apply 将 p 合并到控制状态
+func (ctl *Controls) apply(p controlPatch) {
+	now := ctl.clock.Now()
+
+	ctl.mu.Lock()
+	defer ctl.mu.Unlock()
+
+	for k, v := range p.DisabledCommands {
+		if v == nil {
+			delete(ctl.state.DisabledCommands, k)
+			continue
+		}
+		if v.At.IsZero() {
+			v.At = now
+		}
+		ctl.state.DisabledCommands[k] = *v
+	}
+	if p.MaxScanLimit != nil {
+		ctl.state.MaxScanLimit = *p.MaxScanLimit
+	}
+	if p.GlobalRateLimit != nil {
+		ctl.state.GlobalRateLimit = *p.GlobalRateLimit
+	}
+}
+
+// admit 在发送前应用控制: 拒绝被禁用的命令, 收紧 Scan 的 limit, 并按速率限制等待
+func (ctl *Controls) admit(cmd *Command) error {
+	ctl.mu.Lock()
+
+	if reason, ok := ctl.state.DisabledCommands[cmd.Type]; ok {
+		ctl.mu.Unlock()
+		if reason.By != "" || reason.Reason != "" {
+			return fmt.Errorf("%w: %s (%s 于 %s: %s)", ErrAdministrativelyDisabled,
+				cmd.Type, reason.By, reason.At.Format(time.RFC3339), reason.Reason)
+		}
+		return fmt.Errorf("%w: %s", ErrAdministrativelyDisabled, cmd.Type)
+	}
+
+	if maxLimit := ctl.state.MaxScanLimit; cmd.Type == "Scan" && maxLimit > 0 && (cmd.Limit == nil || *cmd.Limit == 0 || *cmd.Limit > maxLimit) {
+		if cmd.exactLimit {
+			ctl.mu.Unlock()
+			return fmt.Errorf("%w: Scan limit %d 超过运行时上限 %d", ErrInvalidArgument, *cmd.Limit, maxLimit)
+		}
+		cmd.Limit = &maxLimit
+	}
+
+	var wait time.Duration
+	if ops := ctl.state.GlobalRateLimit; ops > 0 {
+		now := ctl.clock.Now()
+		if ctl.next.Before(now) {
+			ctl.next = now
+		}
+		wait = ctl.next.Sub(now)
+		ctl.next = ctl.next.Add(time.Duration(float64(time.Second) / ops))
+	}
+	ctl.mu.Unlock()
+
+	if wait > 0 {
+		if err := ctl.clock.Sleep(ctl.done, wait); err != nil {
+			return ErrClosed
+		}
+	}
+	return nil
+}
+
+// ServeHTTP 管理接口: GET 返回当前控制状态, POST 将 JSON 中出现的字段合并到控制状态
+//
+// 例如 {"disabled_commands": {"Scan": {"by": "oncall", "reason": "事故"}}} 只禁用 Scan,
+// {"disabled_commands": {"Scan": null}} 恢复 Scan, 其余设置保持不变.
+func (ctl *Controls) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var p controlPatch
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, fmt.Sprintf("解析控制状态失败: %v", err), http.StatusBadRequest)
+			return
+		}
+		ctl.apply(p)
+	default:
+		http.Error(w, "只支持 GET 和 POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ctl.State())
+}
+
+// Clock 客户端使用的时间来源, 默认为系统时钟, 测试中用 WithClock 替换为可控的时钟
+//
+// 网络读写的超时仍然使用系统时钟, 因为连接的 deadline 由内核判断.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+	// Sleep 等待 d 或 ctx 结束, 后者返回 ctx.Err()
+	Sleep(ctx context.Context, d time.Duration) error
+}
+
+// Timer Clock 创建的一次性定时器
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+}
+
+// realClock 系统时钟
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTimer(d time.Duration) Timer { return realTimer{time.NewTimer(d)} }
+
+func (realClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type realTimer struct{ t *time.Timer }
+
+func (r realTimer) C() <-chan time.Time { return r.t.C }
+
+func (r realTimer) Stop() bool { return r.t.Stop() }
+
+// 抓包记录的方向
+const (
+	CaptureSent     byte = 'S' // 客户端发出的命令
+	CaptureReceived byte = 'R' // 服务器返回的响应
+)
+
+// captureHeaderSize 抓包记录头: 方向(1) + 时间戳纳秒(8) + 连接编号(4) + 帧长度(4), 大端序
+const captureHeaderSize = 1 + 8 + 4 + 4
+
+// CaptureRecord 抓包文件中的一条记录, Frame 为线上原始字节
+type CaptureRecord struct {
+	Direction byte
+	Time      time.Time
+	ConnID    uint32
+	Frame     []byte
+}
+
+// capture 写出一条记录, 调用方需持有 c.mu; 写入失败不影响请求本身
+func (c *Client) capture(direction byte, frame []byte) {
+	if c.captureW == nil {
+		return
+	}
+
+	var hdr [captureHeaderSize]byte
+	hdr[0] = direction
+	binary.BigEndian.PutUint64(hdr[1:9], uint64(c.clock.Now().UnixNano()))
+	binary.BigEndian.PutUint32(hdr[9:13], c.connID)
+	binary.BigEndian.PutUint32(hdr[13:17], uint32(len(frame)))
+
+	if _, err := c.captureW.Write(hdr[:]); err != nil {
+		return
+	}
+	c.captureW.Write(frame)
+}
+
+// ReadCaptureRecord 从抓包文件读取下一条记录, 文件结束时返回 io.EOF
+func ReadCaptureRecord(r io.Reader) (*CaptureRecord, error) {
+	var hdr [captureHeaderSize]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("抓包记录头不完整: %w", err)
+		}
+		return nil, err
+	}
+
+	rec := &CaptureRecord{
+		Direction: hdr[0],
+		Time:      time.Unix(0, int64(binary.BigEndian.Uint64(hdr[1:9]))),
+		ConnID:    binary.BigEndian.Uint32(hdr[9:13]),
+		Frame:     make([]byte, binary.BigEndian.Uint32(hdr[13:17])),
+	}
+	if rec.Direction != CaptureSent && rec.Direction != CaptureReceived {
+		return nil, fmt.Errorf("未知的抓包方向: %q", rec.Direction)
+	}
+	if _, err := io.ReadFull(r, rec.Frame); err != nil {
+		return nil, fmt.Errorf("抓包帧不完整: %w", err)
+	}
+
+	return rec, nil
+}
+
+// defaultErrorHistorySize 默认保留的失败操作条数
+const defaultErrorHistorySize = 100
+
+// 错误分类
+const (
+	ErrorClassTimeout  = "timeout"  // 超时
+	ErrorClassNetwork  = "network"  // 连接或编解码失败
+	ErrorClassServer   = "server"   // 服务器返回错误
+	ErrorClassDisabled = "disabled" // 被运行时控制拒绝
+	ErrorClassJournal  = "journal"  // 操作已完成, 但请求日志的完成记录写入失败
+)
+
+// ErrorRecord 一次失败操作的上下文, 不包含值
+type ErrorRecord struct {
+	Seq          uint64        `json:"seq"`
+	Label        string        `json:"label,omitempty"`         // 客户端标签
+	RequestLabel string        `json:"request_label,omitempty"` // 调用方 ctx 中的请求标签
+	Time         time.Time     `json:"time"`
+	Command      string        `json:"command"`
+	CF           string        `json:"cf,omitempty"`
+	Key          string        `json:"key,omitempty"` // 经 formatKey 转义
+	Class        string        `json:"class"`
+	Error        string        `json:"error"`
+	Attempt      int           `json:"attempt"` // 同一操作的第几次尝试, 从 1 开始
+	ConnID       uint32        `json:"conn_id"`
+	Latency      time.Duration `json:"latency"`
+}
+
+type requestLabelKey struct{}
+
+type attemptKey struct{}
+
+// WithRequestLabel 返回带有请求标签的 ctx, 如应用的请求 ID
+//
+// 经 PutContext 等方法以及 DeleteRange 发出的请求失败时, 标签记入错误历史,
+// 用于与应用日志对照.
+func WithRequestLabel(ctx context.Context, label string) context.Context {
+	return context.WithValue(ctx, requestLabelKey{}, label)
+}
+
+// RequestLabel 返回 ctx 中的请求标签, 没有时为空
+func RequestLabel(ctx context.Context) string {
+	label, _ := ctx.Value(requestLabelKey{}).(string)
+	return label
+}
+
+// withAttempt 标记 ctx 中的请求是同一操作的第 n 次尝试
+func withAttempt(ctx context.Context, n int) context.Context {
+	return context.WithValue(ctx, attemptKey{}, n)
+}
+
+// fromContext 从 ctx 取出请求标签和尝试次数
+func (cmd *Command) fromContext(ctx context.Context) {
+	cmd.requestLabel = RequestLabel(ctx)
+	cmd.attempt, _ = ctx.Value(attemptKey{}).(int)
+}
+
+// errorHistory 固定大小的失败操作环形缓冲区, 写入只需一次原子递增
+type errorHistory struct {
+	next    atomic.Uint64
+	entries []atomic.Pointer[ErrorRecord]
+}
+
+func newErrorHistory(size int) *errorHistory {
+	return &errorHistory{entries: make([]atomic.Pointer[ErrorRecord], size)}
+}
+
+func (h *errorHistory) record(rec *ErrorRecord) {
+	rec.Seq = h.next.Add(1) - 1
+	h.entries[rec.Seq%uint64(len(h.entries))].Store(rec)
+}
+
+// snapshot 按发生顺序返回当前保留的记录
+func (h *errorHistory) snapshot() []ErrorRecord {
+	records := make([]ErrorRecord, 0, len(h.entries))
+	for i := range h.entries {
+		if rec := h.entries[i].Load(); rec != nil {
+			records = append(records, *rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool { return records[i].Seq < records[j].Seq })
+	return records
+}
+
+// RecentErrors 返回最近的失败操作, 从旧到新排列; 禁用错误历史时返回 nil
+func (c *Client) RecentErrors() []ErrorRecord {
+	if c.errHistory == nil {
+		return nil
+	}
+	return c.errHistory.snapshot()
+}
+
+// recordError 记录失败的操作
+func (c *Client) recordError(cmd Command, connID uint32, start time.Time, class, msg string) {
+	if c.errHistory == nil {
+		return
+	}
+
+	rec := &ErrorRecord{
+		Label:        c.label,
+		RequestLabel: cmd.requestLabel,
+		Time:         start,
+		Command:      cmd.Type,
+		CF:           cmd.CF,
+		Class:        class,
+		Error:        msg,
+		Attempt:      max(cmd.attempt, 1),
+		ConnID:       connID,
+		Latency:      c.clock.Now().Sub(start),
+	}
+	if cmd.Key != nil {
+		rec.Key = formatKey(cmd.Key)
+	}
+	c.errHistory.record(rec)
+}
+
+// classifyError 返回错误分类
+func classifyError(err error) string {
+	switch {
+	case errors.Is(err, ErrAdministrativelyDisabled):
+		return ErrorClassDisabled
+	case isTimeout(err):
+		return ErrorClassTimeout
+	default:
+		return ErrorClassNetwork
+	}
+}
+
+// formatKey 将键转义为可打印的形式, 用于日志和诊断
+func formatKey(key []byte) string {
+	return strconv.QuoteToASCII(string(key))
+}
+
+// ServeHTTP 管理接口: GET 返回最近的失败操作
+func (h *errorHistory) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只支持 GET", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.snapshot())
+}
+
+const (
+	// valueSizeWarningQueue 待投递的告警上限, 队列满时丢弃并计数
+	valueSizeWarningQueue = 64
+	// valueSizeWarningInterval 同一前缀两次回调之间的最短间隔
+	valueSizeWarningInterval = time.Minute
+	// valueSizePrefixMax 键前缀的最大字节数
+	valueSizePrefixMax = 16
+	// valueSizeMaxBuckets 分别统计和限流的 "cf/前缀" 个数上限
+	valueSizeMaxBuckets = 1024
+	// valueSizeOtherPrefix 超出 valueSizeMaxBuckets 后新前缀的计数归入 "cf/*";
+	// formatKey 的结果总带引号, 不会与之冲突
+	valueSizeOtherPrefix = "*"
+	// valueSizeResolution 值大小分布中每个 2 倍区间的桶数
+	valueSizeResolution = 4
+	// valueSizeDoublings 值大小分布覆盖的 2 倍区间数, 更大的值计入最后一个桶
+	valueSizeDoublings = 32
+)
+
+// valueSizeBounds 值大小分布各桶的上界 (含), 第 i 个桶为 (valueSizeBounds[i-1], valueSizeBounds[i]]
+var valueSizeBounds = func() []int {
+	bounds := make([]int, valueSizeDoublings*valueSizeResolution+1)
+	for i := range bounds {
+		bounds[i] = int(math.Ceil(math.Exp2(float64(i) / valueSizeResolution)))
+	}
+	return bounds
+}()
+
+// valueSizeHistogram 一个 "cf/前缀" 写入值大小的分布
+type valueSizeHistogram struct {
+	counts [valueSizeDoublings*valueSizeResolution + 2]uint64 // 最后一个桶没有上界
+	total  uint64
+	max    int
+}
+
+// percentile 返回第 p 百分位所在桶的上界, 不超过实际最大值
+func (h *valueSizeHistogram) percentile(p float64) int {
+	rank := uint64(math.Ceil(p / 100 * float64(h.total)))
+	var seen uint64
+	for i, n := range h.counts {
+		seen += n
+		if seen >= rank && seen > 0 {
+			if i < len(valueSizeBounds) {
+				return min(valueSizeBounds[i], h.max)
+			}
+			break
+		}
+	}
+	return h.max
+}
+
+// ValueSizeWarning 写入的值超过软阈值
+type ValueSizeWarning struct {
+	Label  string
+	Time   time.Time
+	CF     string
+	Key    string // 经 formatKey 转义
+	Prefix string // 统计和限流使用的键前缀, 见 keyPrefix
+	Size   int
+}
+
+// valueSizeWatcher 检查写入值的大小, 在独立的协程中调用回调, 不阻塞写路径
+type valueSizeWatcher struct {
+	label     string
+	threshold int
+	fn        func(ValueSizeWarning)
+	clock     Clock
+
+	queue    chan ValueSizeWarning
+	done     chan struct{}
+	stopOnce sync.Once
+
+	mu         sync.Mutex
+	lastWarned map[string]time.Time           // cf + 前缀 -> 上次回调时间
+	counts     map[string]uint64              // cf + 前缀 -> 超过阈值的次数
+	sizes      map[string]*valueSizeHistogram // cf + 前缀 -> 全部写入值的大小分布
+	dropped    atomic.Uint64
+}
+
+func newValueSizeWatcher(label string, threshold int, fn func(ValueSizeWarning), clock Clock) *valueSizeWatcher {
+	w := &valueSizeWatcher{
+		label:      label,
+		threshold:  threshold,
+		fn:         fn,
+		clock:      clock,
+		queue:      make(chan ValueSizeWarning, valueSizeWarningQueue),
+		done:       make(chan struct{}),
+		lastWarned: map[string]time.Time{},
+		counts:     map[string]uint64{},
+		sizes:      map[string]*valueSizeHistogram{},
+	}
+	go w.dispatch()
+	return w
+}
+
+func (w *valueSizeWatcher) dispatch() {
+	for {
+		select {
+		case warning := <-w.queue:
+			// 回调没有发起方可以返回错误, panic 输出到标准错误后继续投递
+			if err := callUser("值大小告警回调", func() error { w.fn(warning); return nil }); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+			}
+		case <-w.done:
+			return
+		}
+	}
+}
+
+func (w *valueSizeWatcher) stop() {
+	w.stopOnce.Do(func() { close(w.done) })
+}
+
+// check 记录值的大小; 超过阈值时计数, 并在该前缀未被限流时排队一次回调
+func (w *valueSizeWatcher) check(cf string, key []byte, size int) {
+	prefix := keyPrefix(key)
+	bucket := cf + "/" + prefix
+
+	w.mu.Lock()
+	w.recordSizeLocked(cf, bucket, size)
+	if size <= w.threshold {
+		w.mu.Unlock()
+		return
+	}
+
+	now := w.clock.Now()
+	if _, ok := w.counts[bucket]; ok || len(w.counts) < valueSizeMaxBuckets {
+		w.counts[bucket]++
+	} else {
+		w.counts[cf+"/"+valueSizeOtherPrefix]++
+	}
+	last, seen := w.lastWarned[bucket]
+	notify := !seen || now.Sub(last) >= valueSizeWarningInterval
+	full := false
+	if !seen && len(w.lastWarned) >= valueSizeMaxBuckets {
+		w.evictWarnedLocked(now)
+		full = len(w.lastWarned) >= valueSizeMaxBuckets
+	}
+	if notify && !full {
+		w.lastWarned[bucket] = now
+	}
+	w.mu.Unlock()
+
+	if full {
+		// 一分钟内告警过的前缀太多, 无法再限流新前缀, 按丢弃处理
+		w.dropped.Add(1)
+		return
+	}
+	if !notify {
+		return
+	}
+
+	warning := ValueSizeWarning{Label: w.label, Time: now, CF: cf, Key: formatKey(key), Prefix: prefix, Size: size}
+	select {
+	case w.queue <- warning:
+	default:
+		w.dropped.Add(1)
+	}
+}
+
+// recordSizeLocked 将 size 计入 bucket 的分布; 前缀个数达到上限后新前缀计入 "cf/*"
+func (w *valueSizeWatcher) recordSizeLocked(cf, bucket string, size int) {
+	h, ok := w.sizes[bucket]
+	if !ok {
+		if len(w.sizes) >= valueSizeMaxBuckets {
+			bucket = cf + "/" + valueSizeOtherPrefix
+			h = w.sizes[bucket]
+		}
+		if h == nil {
+			h = &valueSizeHistogram{}
+			w.sizes[bucket] = h
+		}
+	}
+	h.counts[sort.SearchInts(valueSizeBounds, size)]++
+	h.total++
+	h.max = max(h.max, size)
+}
+
+// evictWarnedLocked 删除已超过限流间隔的 lastWarned 记录, 它们不再影响是否回调
+func (w *valueSizeWatcher) evictWarnedLocked(now time.Time) {
+	for bucket, last := range w.lastWarned {
+		if now.Sub(last) >= valueSizeWarningInterval {
+			delete(w.lastWarned, bucket)
+		}
+	}
+}
+
+// keyPrefix 返回键中第一个 ':' 及之前的部分, 没有 ':' 时为整个键; 最多取
+// valueSizePrefixMax 字节, 避免没有分隔符的键各自成为一组
+func keyPrefix(key []byte) string {
+	if i := bytes.IndexByte(key, ':'); i >= 0 {
+		key = key[:i+1]
+	}
+	if len(key) > valueSizePrefixMax {
+		key = key[:valueSizePrefixMax]
+	}
+	return formatKey(key)
+}
+
+// ValueSizeWarnings 返回各 "cf/前缀" 超过软阈值的写入次数, 以及因队列满而丢弃的回调数
+//
+// 前缀超过 1024 个后, 新前缀的次数计入 "cf/*"; 一分钟内告警过的前缀超过 1024 个时,
+// 新前缀的回调同样丢弃并计数.
+func (c *Client) ValueSizeWarnings() (map[string]uint64, uint64) {
+	if c.sizeWatcher == nil {
+		return nil, 0
+	}
+
+	w := c.sizeWatcher
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	counts := make(map[string]uint64, len(w.counts))
+	for k, v := range w.counts {
+		counts[k] = v
+	}
+	return counts, w.dropped.Load()
+}
+
+// ValueSizeStats 一个 "cf/前缀" 写入值大小的分布
+//
+// 百分位是所在桶的上界 (每个 2 倍区间 4 个桶, 误差在 19% 以内), 不超过 Max.
+type ValueSizeStats struct {
+	Prefix string // "cf/前缀", 与 ValueSizeWarnings 的键相同
+	Count  uint64
+	P50    int
+	P90    int
+	P99    int
+	Max    int
+}
+
+// ValueSizePercentiles 返回启用 WithValueSizeWarning 以来各 "cf/前缀" 全部写入值
+// (不论是否超过阈值) 的大小分布, 按 P99 从大到小排列, 便于找出值最大的前缀;
+// 未启用时返回 nil. 前缀个数的上限与 ValueSizeWarnings 相同.
+func (c *Client) ValueSizePercentiles() []ValueSizeStats {
+	if c.sizeWatcher == nil {
+		return nil
+	}
+
+	w := c.sizeWatcher
+	w.mu.Lock()
+	stats := make([]ValueSizeStats, 0, len(w.sizes))
+	for prefix, h := range w.sizes {
+		stats = append(stats, ValueSizeStats{
+			Prefix: prefix,
+			Count:  h.total,
+			P50:    h.percentile(50),
+			P90:    h.percentile(90),
+			P99:    h.percentile(99),
+			Max:    h.max,
+		})
+	}
+	w.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].P99 != stats[j].P99 {
+			return stats[i].P99 > stats[j].P99
+		}
+		return stats[i].Prefix < stats[j].Prefix
+	})
+	return stats
+}
+
+// DryRunEntry 试运行中被跳过的一个修改类命令
+type DryRunEntry struct {
+	Time      time.Time
+	Command   string
+	CF        string
+	Key       string // 经 formatKey 转义
+	ValueSize int
+}
+
+// dryRunLog 按顺序记录试运行中跳过的命令
+type dryRunLog struct {
+	mu      sync.Mutex
+	entries []DryRunEntry
+}
+
+// skipsInDryRun 判断命令在试运行中是否跳过; Flush 不改变数据但会写盘, 同样跳过
+func skipsInDryRun(cmdType string) bool {
+	return mutatingCommands[cmdType] || cmdType == "Flush"
+}
+
+func (l *dryRunLog) record(entry DryRunEntry) {
+	l.mu.Lock()
+	l.entries = append(l.entries, entry)
+	l.mu.Unlock()
+}
+
+// DryRunLog 返回试运行中被跳过的修改类命令, 按发出顺序排列; 未启用试运行时返回 nil
+func (c *Client) DryRunLog() []DryRunEntry {
+	if c.dryRun == nil {
+		return nil
+	}
+
+	c.dryRun.mu.Lock()
+	defer c.dryRun.mu.Unlock()
+
+	return append([]DryRunEntry(nil), c.dryRun.entries...)
+}
+
+const (
+	// defaultLatencyResolution WithLatencyLog 未指定精度时每个 2 倍区间的桶数
+	defaultLatencyResolution = 4
+	// maxLatencyResolution 每个 2 倍区间最多的桶数
+	maxLatencyResolution = 64
+	// latencyBase 第一个桶的上界, 更短的延迟都计入第一个桶
+	latencyBase = time.Microsecond
+	// latencyDoublings 从 latencyBase 起覆盖的 2 倍区间数 (约 18 分钟), 更长的计入最后一个桶
+	latencyDoublings = 30
+)
+
+// LatencyHistogram 一种命令的延迟分布
+//
+// Counts[i] 是延迟落在 [Bounds[i-1], Bounds[i]) 的次数, Bounds[-1] 视为 0;
+// 最后一个桶没有上界, 因此 len(Counts) == len(Bounds)+1.
+type LatencyHistogram struct {
+	Time    time.Time       `json:"time"`
+	Label   string          `json:"label,omitempty"` // 客户端标签, 多个客户端写同一文件时用于区分
+	Command string          `json:"command"`
+	Bounds  []time.Duration `json:"bounds"`
+	Counts  []uint64        `json:"counts"`
+}
+
+// latencyRecorder 按命令类型累计延迟直方图, 记录一次只需一次原子递增
+//
+// 计数只增不减; 需要区间数据的使用方保存上一次的累计值并相减, 因此快照之间不会
+// 丢失或重复计数, 多个使用方之间也互不影响.
+type latencyRecorder struct {
+	label      string
+	resolution int
+	bounds     []time.Duration
+	hists      sync.Map // 命令类型 -> []atomic.Uint64
+}
+
+func newLatencyRecorder(label string, resolution int) *latencyRecorder {
+	r := &latencyRecorder{label: label, resolution: resolution}
+	for i := 1; i <= latencyDoublings*resolution; i++ {
+		r.bounds = append(r.bounds, time.Duration(float64(latencyBase)*math.Exp2(float64(i-1)/float64(resolution))))
+	}
+	return r
+}
+
+// bucket 返回延迟所在桶的下标
+func (r *latencyRecorder) bucket(d time.Duration) int {
+	if d < latencyBase {
+		return 0
 	}
+	i := int(math.Log2(float64(d)/float64(latencyBase))*float64(r.resolution)) + 1
+	if i > len(r.bounds) {
+		i = len(r.bounds)
+	}
+	// 浮点误差可能使刚好落在边界上的值偏移一个桶, 以 bounds 为准修正
+	for i > 0 && i <= len(r.bounds) && d < r.bounds[i-1] {
+		i--
+	}
+	for i < len(r.bounds) && d >= r.bounds[i] {
+		i++
+	}
+	return i
+}
 
-	return &Client{
-		conn: conn,
-	}, nil
+func (r *latencyRecorder) counts(cmdType string) []atomic.Uint64 {
+	if v, ok := r.hists.Load(cmdType); ok {
+		return v.([]atomic.Uint64)
+	}
+	v, _ := r.hists.LoadOrStore(cmdType, make([]atomic.Uint64, len(r.bounds)+1))
+	return v.([]atomic.Uint64)
 }
 
-// Close 关闭连接
-func (c *Client) Close() error {
-	return c.conn.Close()
+func (r *latencyRecorder) record(cmdType string, d time.Duration) {
+	r.counts(cmdType)[r.bucket(d)].Add(1)
 }
 
-// sendCommand 发送命令
-func (c *Client) sendCommand(cmd Command) error {
-	data, err := json.Marshal(cmd)
-	if err != nil {
-		return fmt.Errorf("序列化命令失败: %w", err)
+// snapshot 返回各命令类型的累计直方图, 按命令类型排序
+func (r *latencyRecorder) snapshot(now time.Time) []LatencyHistogram {
+	var hists []LatencyHistogram
+	r.hists.Range(func(k, v interface{}) bool {
+		counts := v.([]atomic.Uint64)
+		h := LatencyHistogram{Time: now, Label: r.label, Command: k.(string), Bounds: r.bounds, Counts: make([]uint64, len(counts))}
+		for i := range counts {
+			h.Counts[i] = counts[i].Load()
+		}
+		hists = append(hists, h)
+		return true
+	})
+	sort.Slice(hists, func(i, j int) bool { return hists[i].Command < hists[j].Command })
+	return hists
+}
+
+// LatencyHistograms 返回自客户端创建以来各命令类型的累计延迟直方图; 未启用时返回 nil
+func (c *Client) LatencyHistograms() []LatencyHistogram {
+	if c.latency == nil {
+		return nil
 	}
+	return c.latency.snapshot(c.clock.Now())
+}
 
-	// 调试输出
-	fmt.Printf("[DEBUG] 发送 JSON: %s\n", string(data))
+// latencyLogger 定期将区间直方图写入文件
+type latencyLogger struct {
+	rec      *latencyRecorder
+	w        io.WriteCloser
+	interval time.Duration
+	clock    Clock
+	last     map[string][]uint64 // 上一次写入时的累计值
+
+	done     chan struct{}
+	finished chan struct{}
+	stopOnce sync.Once
+}
 
-	_, err = c.conn.Write(data)
-	if err != nil {
-		return fmt.Errorf("发送命令失败: %w", err)
+func newLatencyLogger(rec *latencyRecorder, w io.WriteCloser, interval time.Duration, clock Clock) *latencyLogger {
+	l := &latencyLogger{
+		rec:      rec,
+		w:        w,
+		interval: interval,
+		clock:    clock,
+		last:     map[string][]uint64{},
+		done:     make(chan struct{}),
+		finished: make(chan struct{}),
 	}
-
-	return nil
+	go l.run()
+	return l
 }
 
-// readResponse 读取响应
-func (c *Client) readResponse() (*Response, error) {
-	buffer := make([]byte, 8192)
-	n, err := c.conn.Read(buffer)
-	if err != nil {
-		if err == io.EOF {
-			return nil, fmt.Errorf("服务器关闭连接")
+func (l *latencyLogger) run() {
+	defer close(l.finished)
+	defer l.w.Close()
+
+	for {
+		timer := l.clock.NewTimer(l.interval)
+		select {
+		case <-timer.C():
+			l.flush()
+		case <-l.done:
+			timer.Stop()
+			l.flush()
+			return
 		}
-		return nil, fmt.Errorf("读取响应失败: %w", err)
 	}
+}
 
-	fmt.Printf("[DEBUG] 收到响应: %s\n", string(buffer[:n]))
+// flush 写入自上次以来的增量, 没有新样本的命令类型不写
+func (l *latencyLogger) flush() {
+	enc := json.NewEncoder(l.w)
+	for _, h := range l.rec.snapshot(l.clock.Now()) {
+		cumulative := h.Counts
+		last := l.last[h.Command]
+		delta := make([]uint64, len(cumulative))
+		var total uint64
+		for i := range cumulative {
+			delta[i] = cumulative[i]
+			if last != nil {
+				delta[i] -= last[i]
+			}
+			total += delta[i]
+		}
+		l.last[h.Command] = cumulative
+		if total == 0 {
+			continue
+		}
 
-	var resp Response
-	if err := json.Unmarshal(buffer[:n], &resp); err != nil {
-		return nil, fmt.Errorf("解析响应失败: %w", err)
+		h.Counts = delta
+		if err := enc.Encode(h); err != nil {
+			fmt.Fprintf(os.Stderr, "写入延迟日志失败: %v\n", err)
+			return
+		}
 	}
+}
 
-	return &resp, nil
+// stop 写入最后一个区间并关闭文件
+func (l *latencyLogger) stop() {
+	l.stopOnce.Do(func() { close(l.done) })
+	<-l.finished
 }
 
-// decodeValue 解码响应中的值
-func decodeValue(data interface{}) (string, error) {
-	if data == nil {
-		return "", fmt.Errorf("值为 nil")
-	}
+// clients 进程内所有未关闭的客户端
+var clients = &clientRegistry{clients: map[*Client]struct{}{}}
 
-	// 处理 Base64 字符串 (Rust serde_bytes 序列化后的格式)
-	if str, ok := data.(string); ok {
-		return str, nil
+// clientRegistry 记录进程内的客户端, 并保证标签不重复
+type clientRegistry struct {
+	mu      sync.Mutex
+	clients map[*Client]struct{}
+}
+
+func (r *clientRegistry) register(c *Client) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if c.label != "" {
+		for other := range r.clients {
+			if other.label == c.label {
+				return fmt.Errorf("%w: 标签 %q 已被地址为 %s 的客户端使用", ErrInvalidArgument, c.label, other.addr)
+			}
+		}
 	}
+	r.clients[c] = struct{}{}
+	return nil
+}
 
-	return "", fmt.Errorf("不支持的值类型: %T", data)
+func (r *clientRegistry) unregister(c *Client) {
+	r.mu.Lock()
+	delete(r.clients, c)
+	r.mu.Unlock()
 }
 
-// Put 存储键值对
-func (c *Client) Put(cf, key, value string) error {
-	cmd := Command{
-		Type:  "Put",
-		CF:    cf,
-		Key:   []byte(key),   // 直接转字节,json.Marshal 会自动 Base64 编码
-		Value: []byte(value), // 直接转字节
-	}
+// ClientInfo 进程内一个客户端的概况
+type ClientInfo struct {
+	Label        string         `json:"label,omitempty"`
+	Addr         string         `json:"addr"`
+	DryRun       bool           `json:"dry_run"`
+	Reconnects   ReconnectStats `json:"reconnects"`
+	RecentErrors int            `json:"recent_errors"`
+}
 
-	if err := c.sendCommand(cmd); err != nil {
-		return err
+// Clients 列出进程内所有未关闭的客户端, 按标签和地址排序, 未设置标签的排在最前
+//
+// 不获取客户端的请求锁, 正在执行的请求不会阻塞调用方.
+func Clients() []ClientInfo {
+	clients.mu.Lock()
+	list := make([]*Client, 0, len(clients.clients))
+	for c := range clients.clients {
+		list = append(list, c)
 	}
-
-	resp, err := c.readResponse()
-	if err != nil {
-		return err
+	clients.mu.Unlock()
+
+	infos := make([]ClientInfo, 0, len(list))
+	for _, c := range list {
+		infos = append(infos, ClientInfo{
+			Label:        c.label,
+			Addr:         c.addr,
+			DryRun:       c.dryRun != nil,
+			Reconnects:   c.Reconnects(),
+			RecentErrors: len(c.RecentErrors()),
+		})
 	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Label != infos[j].Label {
+			return infos[i].Label < infos[j].Label
+		}
+		return infos[i].Addr < infos[j].Addr
+	})
+	return infos
+}
 
-	if resp.Error != "" {
-		return fmt.Errorf("Put 失败: %s", resp.Error)
+// serveClients 以 JSON 返回 Clients 的结果
+func serveClients(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "只支持 GET", http.StatusMethodNotAllowed)
+		return
 	}
 
-	return nil
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(Clients())
 }
 
-// Get 获取值
-func (c *Client) Get(cf, key string) (string, bool, error) {
-	cmd := Command{
-		Type: "Get",
-		CF:   cf,
-		Key:  []byte(key),
+// auditTag 审计日志的前缀, 设置了标签时包含标签
+func (c *Client) auditTag() string {
+	if c.label == "" {
+		return "[AUDIT]"
 	}
+	return "[AUDIT " + c.label + "]"
+}
 
-	if err := c.sendCommand(cmd); err != nil {
-		return "", false, err
+// debugTag 调试输出的前缀, 设置了标签时包含标签
+func (c *Client) debugTag() string {
+	if c.label == "" {
+		return "[DEBUG]"
 	}
+	return "[DEBUG " + c.label + "]"
+}
 
-	resp, err := c.readResponse()
-	if err != nil {
-		return "", false, err
-	}
+// ErrClosed 客户端已关闭
+var ErrClosed = errors.New("客户端已关闭")
 
-	if resp.Error != "" {
-		return "", false, fmt.Errorf("Get 失败: %s", resp.Error)
-	}
+// ErrNoDefaultClient 未设置默认客户端, 且环境变量 TINYKV_ADDR 为空
+var ErrNoDefaultClient = errors.New("未设置默认客户端")
 
-	// 检查是否找到值
-	if resp.Value == nil {
-		return "", false, nil
-	}
+// KV 常用操作, 库代码应依赖该接口而不是包级函数, 便于注入和测试
+type KV interface {
+	Put(cf, key, value string, opts ...PutOption) error
+	Get(cf, key string) (string, bool, error)
+	Delete(cf, key string) error
+	ScanItems(cf, startKey string, endKey *string, limit int) ([]ScanItem, error)
+}
 
-	// 解码值
-	value, err := decodeValue(resp.Value)
-	if err != nil {
-		return "", false, fmt.Errorf("解码值失败: %w", err)
+var _ KV = (*Client)(nil)
+
+// defaultClient 包级函数使用的客户端
+var defaultClient struct {
+	mu    sync.Mutex
+	c     *Client
+	owned bool // 由环境变量创建, ResetDefault 时负责关闭
+}
+
+// SetDefault 设置包级函数使用的客户端
+//
+// 之前通过 SetDefault 设置的客户端由调用方负责关闭; 从 TINYKV_ADDR 创建的客户端在此关闭.
+func SetDefault(c *Client) {
+	defaultClient.mu.Lock()
+	defer defaultClient.mu.Unlock()
+
+	if defaultClient.owned {
+		defaultClient.c.Close()
 	}
+	defaultClient.c = c
+	defaultClient.owned = false
+}
 
-	return value, true, nil
+// ResetDefault 清除默认客户端, 主要用于测试; 由环境变量创建的客户端会被关闭
+func ResetDefault() {
+	SetDefault(nil)
 }
 
-// Delete 删除键
-func (c *Client) Delete(cf, key string) error {
-	cmd := Command{
-		Type: "Delete",
-		CF:   cf,
-		Key:  []byte(key),
+// Default 返回默认客户端
+//
+// 未调用 SetDefault 时, 第一次使用时连接环境变量 TINYKV_ADDR 指定的地址; 连接失败
+// 不会被缓存, 下次调用会重试. 默认客户端被关闭后, 操作返回 ErrClosed.
+func Default() (*Client, error) {
+	defaultClient.mu.Lock()
+	defer defaultClient.mu.Unlock()
+
+	if defaultClient.c != nil {
+		return defaultClient.c, nil
 	}
 
-	if err := c.sendCommand(cmd); err != nil {
-		return err
+	addr := os.Getenv("TINYKV_ADDR")
+	if addr == "" {
+		return nil, ErrNoDefaultClient
 	}
+	c, err := NewClient(addr)
+	if err != nil {
+		return nil, fmt.Errorf("连接 TINYKV_ADDR (%s) 失败: %w", addr, err)
+	}
+	defaultClient.c = c
+	defaultClient.owned = true
+	return c, nil
+}
 
-	resp, err := c.readResponse()
+// Put 使用默认客户端存储键值对
+func Put(cf, key, value string, opts ...PutOption) error {
+	c, err := Default()
 	if err != nil {
 		return err
 	}
+	return c.Put(cf, key, value, opts...)
+}
 
-	if resp.Error != "" {
-		return fmt.Errorf("Delete 失败: %s", resp.Error)
+// Get 使用默认客户端获取值
+func Get(cf, key string) (string, bool, error) {
+	c, err := Default()
+	if err != nil {
+		return "", false, err
 	}
-
-	return nil
+	return c.Get(cf, key)
 }
 
-// Scan 扫描范围
-func (c *Client) Scan(cf, startKey string, endKey *string, limit int) ([]map[string]string, error) {
-	cmd := Command{
-		Type:     "Scan",
-		CF:       cf,
-		StartKey: []byte(startKey),
-		Limit:    limit,
+// Delete 使用默认客户端删除键
+func Delete(cf, key string) error {
+	c, err := Default()
+	if err != nil {
+		return err
 	}
+	return c.Delete(cf, key)
+}
 
-	if endKey != nil {
-		endKeyBytes := []byte(*endKey)
-		cmd.EndKey = &endKeyBytes
+// Scan 使用默认客户端扫描范围
+//
+// Deprecated: 使用 ScanItems.
+func Scan(cf, startKey string, endKey *string, limit int) ([]map[string]string, error) {
+	c, err := Default()
+	if err != nil {
+		return nil, err
 	}
-
-	if err := c.sendCommand(cmd); err != nil {
+	if err := c.warnDeprecated("Scan", "ScanItems"); err != nil {
 		return nil, err
 	}
+	items, err := c.ScanItems(cf, startKey, endKey, limit)
+	return scanItemsToMaps(items), err
+}
 
-	resp, err := c.readResponse()
+// ScanItems 使用默认客户端扫描范围
+func ScanItems(cf, startKey string, endKey *string, limit int) ([]ScanItem, error) {
+	c, err := Default()
 	if err != nil {
 		return nil, err
 	}
+	return c.ScanItems(cf, startKey, endKey, limit)
+}
 
-	if resp.Error != "" {
-		return nil, fmt.Errorf("Scan 失败: %s", resp.Error)
-	}
+// DeprecationWarning 一次对已废弃接口的调用
+//
+// 废弃的接口以 Deprecated 注释标明替代接口, 在替代接口稳定后的下一个不兼容版本中移除.
+type DeprecationWarning struct {
+	API         string
+	Replacement string
+	Caller      string // 调用方的 文件:行号
+}
 
-	// 解析结果 [[key_base64, value_base64], ...]
-	var result []map[string]string
-	if resp.Values != nil {
-		valuesArr, ok := resp.Values.([]interface{})
-		if !ok {
-			return nil, fmt.Errorf("Scan 响应格式错误")
-		}
+// warnDeprecated 报告调用废弃接口的位置, 只能在废弃接口中直接调用; 回调 panic 时
+// 返回 ErrCallbackPanic, 废弃接口以此作为调用结果
+func (c *Client) warnDeprecated(api, replacement string) error {
+	if c.deprecationFn == nil {
+		return nil
+	}
 
-		for _, item := range valuesArr {
-			itemArr, ok := item.([]interface{})
-			if !ok || len(itemArr) != 2 {
-				continue
-			}
+	caller := "未知位置"
+	// 跳过 warnDeprecated 和废弃接口本身
+	if _, file, line, ok := runtime.Caller(2); ok {
+		caller = fmt.Sprintf("%s:%d", file, line)
+	}
+	if _, seen := c.deprecatedSites.LoadOrStore(api+"@"+caller, struct{}{}); seen {
+		return nil
+	}
+	warning := DeprecationWarning{API: api, Replacement: replacement, Caller: caller}
+	return callUser("废弃接口回调", func() error { c.deprecationFn(warning); return nil })
+}
 
-			// 解码 key 和 value (都是 Base64 字符串)
-			key, err := decodeValue(itemArr[0])
-			if err != nil {
-				continue
-			}
+const (
+	// journalFile 请求日志在目录中的文件名
+	journalFile = "journal.log"
+	// journalCompactEvery 写入这么多完成记录后压缩一次, 只保留未完成的意图
+	journalCompactEvery = 1024
+)
 
-			value, err := decodeValue(itemArr[1])
-			if err != nil {
-				continue
-			}
+// JournalEntry 请求日志中的一个修改意图
+type JournalEntry struct {
+	Seq       uint64    `json:"seq"`
+	Time      time.Time `json:"time"`
+	Op        string    `json:"op"` // Put 或 Delete
+	CF        string    `json:"cf"`
+	Key       []byte    `json:"key"`
+	ValueHash string    `json:"value_hash,omitempty"` // Put 的值的 SHA-256, 十六进制
+	Token     string    `json:"token"`                // 幂等令牌
+}
 
-			result = append(result, map[string]string{
-				"key":   key,
-				"value": value,
-			})
-		}
-	}
+// journalRecord 日志中的一行: 发送前写入意图, 收到响应后写入完成
+type journalRecord struct {
+	Intent   *JournalEntry `json:"intent,omitempty"`
+	Complete uint64        `json:"complete,omitempty"`
+}
 
-	return result, nil
+// requestJournal 追加写的本地请求日志
+//
+// 每行是 "<CRC32 十六进制> <JSON>\n". 意图记录在发送前 fsync, 并发的请求共用一次
+// fsync; 完成记录不单独 fsync, 丢失时该操作在恢复时按未完成报告, 只会多报不会漏报.
+type requestJournal struct {
+	dir string
+
+	mu        sync.Mutex
+	cond      *sync.Cond
+	f         *os.File
+	next      uint64                  // 下一个意图的序号, 从 1 开始
+	open      map[uint64]JournalEntry // 未完成的意图
+	completed int                     // 上次压缩后写入的完成记录数
+	truncated int64                   // 打开时因损坏截掉的字节数
+
+	writes  uint64 // 已写入的记录数
+	synced  uint64 // 已 fsync 的记录数
+	syncing bool
 }
 
-// Info 获取服务器信息
-func (c *Client) Info() (int, []string, error) {
-	cmd := Command{
-		Type: "Info",
+// openJournal 打开 dir 中的请求日志, 从最后一条有效记录处截断损坏的尾部, 并压缩
+func openJournal(dir string) (*requestJournal, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("创建请求日志目录失败: %w", err)
 	}
 
-	if err := c.sendCommand(cmd); err != nil {
-		return 0, nil, err
-	}
+	j := &requestJournal{dir: dir, next: 1, open: map[uint64]JournalEntry{}}
+	j.cond = sync.NewCond(&j.mu)
 
-	resp, err := c.readResponse()
-	if err != nil {
-		return 0, nil, err
+	path := filepath.Join(dir, journalFile)
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取请求日志失败: %w", err)
 	}
-
-	if resp.Error != "" {
-		return 0, nil, fmt.Errorf("Info 失败: %s", resp.Error)
+	valid := j.load(data)
+	if j.truncated = int64(len(data) - valid); j.truncated > 0 {
+		fmt.Fprintf(os.Stderr, "请求日志 %s 在第 %d 字节处损坏, 截掉其后 %d 字节, 最后几个操作可能需要人工确认\n",
+			path, valid, j.truncated)
 	}
 
-	if resp.Info == nil {
-		return 0, nil, fmt.Errorf("Info 响应为空")
+	if err := j.compactLocked(); err != nil {
+		return nil, err
 	}
+	return j, nil
+}
 
-	totalKeys := 0
-	if tk, ok := resp.Info["total_keys"].(float64); ok {
-		totalKeys = int(tk)
-	}
+// load 重放日志内容, 返回有效记录的总字节数; 第一条无效记录之后的内容全部丢弃
+func (j *requestJournal) load(data []byte) int {
+	valid := 0
+	for valid < len(data) {
+		end := bytes.IndexByte(data[valid:], '\n')
+		if end < 0 {
+			break // 写到一半的记录
+		}
+		rec, ok := parseJournalLine(data[valid : valid+end])
+		if !ok {
+			break
+		}
+		valid += end + 1
 
-	var cfs []string
-	if cfList, ok := resp.Info["column_families"].([]interface{}); ok {
-		for _, cf := range cfList {
-			if cfStr, ok := cf.(string); ok {
-				cfs = append(cfs, cfStr)
+		if rec.Intent != nil {
+			j.open[rec.Intent.Seq] = *rec.Intent
+			if rec.Intent.Seq >= j.next {
+				j.next = rec.Intent.Seq + 1
 			}
+		} else {
+			delete(j.open, rec.Complete)
 		}
 	}
+	return valid
+}
+
+// parseJournalLine 校验一行的 CRC 并解析, 不带换行符
+func parseJournalLine(line []byte) (journalRecord, bool) {
+	var rec journalRecord
+	sum, payload, ok := bytes.Cut(line, []byte(" "))
+	if !ok || len(sum) != 8 {
+		return rec, false
+	}
+	want, err := strconv.ParseUint(string(sum), 16, 32)
+	if err != nil || crc32.ChecksumIEEE(payload) != uint32(want) {
+		return rec, false
+	}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return rec, false
+	}
+	return rec, rec.Intent != nil || rec.Complete != 0
+}
 
-	return totalKeys, cfs, nil
+// appendJournalLine 将记录编码为一行追加到 buf
+func appendJournalLine(buf []byte, rec journalRecord) []byte {
+	payload, _ := json.Marshal(rec)
+	buf = fmt.Appendf(buf, "%08x ", crc32.ChecksumIEEE(payload))
+	buf = append(buf, payload...)
+	return append(buf, '\n')
 }
 
-// Flush 刷盘
-func (c *Client) Flush() error {
-	cmd := Command{
-		Type: "Flush",
+// begin 写入并 fsync cmd 的意图记录, 返回其序号; 之后才能发送 cmd
+func (j *requestJournal) begin(cmd Command, now time.Time) (uint64, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return 0, fmt.Errorf("生成幂等令牌失败: %w", err)
 	}
 
-	if err := c.sendCommand(cmd); err != nil {
-		return err
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return 0, ErrClosed
+	}
+	entry := JournalEntry{Seq: j.next, Time: now, Op: cmd.Type, CF: cmd.CF, Key: cmd.Key, Token: hex.EncodeToString(token)}
+	if cmd.Type == "Put" {
+		sum := sha256.Sum256(cmd.Value)
+		entry.ValueHash = hex.EncodeToString(sum[:])
+	}
+	if err := j.writeLocked(journalRecord{Intent: &entry}); err != nil {
+		return 0, err
 	}
+	j.next++
+	j.open[entry.Seq] = entry
 
-	resp, err := c.readResponse()
-	if err != nil {
+	if err := j.syncLocked(j.writes); err != nil {
+		return 0, fmt.Errorf("同步请求日志失败: %w", err)
+	}
+	return entry.Seq, nil
+}
+
+// complete 写入 seq 的完成记录, 不等待 fsync
+func (j *requestJournal) complete(seq uint64) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return ErrClosed
+	}
+	if _, ok := j.open[seq]; !ok {
+		return fmt.Errorf("%w: 请求日志中没有未完成的操作 %d", ErrInvalidArgument, seq)
+	}
+	if err := j.writeLocked(journalRecord{Complete: seq}); err != nil {
 		return err
 	}
+	delete(j.open, seq)
 
-	if resp.Error != "" {
-		return fmt.Errorf("Flush 失败: %s", resp.Error)
+	j.completed++
+	if j.completed >= journalCompactEvery {
+		return j.compactLocked()
 	}
+	return nil
+}
 
+func (j *requestJournal) writeLocked(rec journalRecord) error {
+	if _, err := j.f.Write(appendJournalLine(nil, rec)); err != nil {
+		return fmt.Errorf("写入请求日志失败: %w", err)
+	}
+	j.writes++
 	return nil
 }
 
-// ===================== 示例代码 =====================
+// syncLocked 等待前 target 条记录落盘; 同一时间只有一个 fsync, 期间写入的记录由下一次 fsync 一并覆盖
+func (j *requestJournal) syncLocked(target uint64) error {
+	for j.synced < target {
+		if j.syncing {
+			j.cond.Wait()
+			continue
+		}
 
-func main() {
-	fmt.Println("=== TinyKV Go 客户端示例 ===")
+		j.syncing = true
+		upto, f := j.writes, j.f
+		j.mu.Unlock()
+		err := f.Sync()
+		j.mu.Lock()
+		j.syncing = false
+		j.cond.Broadcast()
 
-	// 连接服务器
-	client, err := NewClient("127.0.0.1:8080")
-	if err != nil {
-		fmt.Printf("✗ 连接失败: %v\n", err)
-		return
+		if err != nil {
+			return err
+		}
+		if upto > j.synced {
+			j.synced = upto
+		}
 	}
-	defer client.Close()
-	fmt.Println("✓ 已连接到服务器: 127.0.0.1:8080")
-
-	// 示例 1: 基本 Put/Get 操作
-	fmt.Println("\n【示例 1】基本 Put/Get 操作")
-	fmt.Println("--------------------------------------------------")
+	return nil
+}
 
-	if err := client.Put("default", "name", "Alice"); err != nil {
-		fmt.Printf("Put 错误: %v\n", err)
-	} else {
-		fmt.Println("✓ Put: cf=default, key=name, value=Alice")
+// compactLocked 用只包含未完成意图的新文件替换日志
+func (j *requestJournal) compactLocked() error {
+	for j.syncing {
+		j.cond.Wait()
 	}
 
-	if value, found, err := client.Get("default", "name"); err != nil {
-		fmt.Printf("Get 错误: %v\n", err)
-	} else if found {
-		fmt.Printf("✓ Get: cf=default, key=name -> %s\n", value)
-	} else {
-		fmt.Println("✓ Get: cf=default, key=name -> 键不存在")
+	seqs := make([]uint64, 0, len(j.open))
+	for seq := range j.open {
+		seqs = append(seqs, seq)
 	}
-
-	// 示例 2: 中文支持
-	fmt.Println("\n【示例 2】中文支持")
-	fmt.Println("--------------------------------------------------")
-
-	if err := client.Put("default", "城市", "北京"); err != nil {
-		fmt.Printf("Put 错误: %v\n", err)
-	} else {
-		fmt.Println("✓ Put: 城市=北京")
+	sort.Slice(seqs, func(a, b int) bool { return seqs[a] < seqs[b] })
+	var buf []byte
+	for _, seq := range seqs {
+		entry := j.open[seq]
+		buf = appendJournalLine(buf, journalRecord{Intent: &entry})
 	}
 
-	if value, found, err := client.Get("default", "城市"); err != nil {
-		fmt.Printf("Get 错误: %v\n", err)
-	} else if found {
-		fmt.Printf("✓ Get: 城市 -> %s\n", value)
+	path := filepath.Join(j.dir, journalFile)
+	tmp := path + ".tmp"
+	if err := writeFileSync(tmp, buf); err != nil {
+		return fmt.Errorf("压缩请求日志失败: %w", err)
 	}
-
-	// 示例 3: Delete 操作
-	fmt.Println("\n【示例 3】Delete 操作")
-	fmt.Println("--------------------------------------------------")
-
-	if err := client.Put("default", "temp", "temporary value"); err != nil {
-		fmt.Printf("Put 错误: %v\n", err)
-	} else {
-		fmt.Println("✓ Put: temp=temporary value")
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("压缩请求日志失败: %w", err)
 	}
-
-	if err := client.Delete("default", "temp"); err != nil {
-		fmt.Printf("Delete 错误: %v\n", err)
-	} else {
-		fmt.Println("✓ Delete: 已删除 temp")
+	if d, err := os.Open(j.dir); err == nil {
+		d.Sync()
+		d.Close()
 	}
 
-	if _, found, err := client.Get("default", "temp"); err != nil {
-		fmt.Printf("Get 错误: %v\n", err)
-	} else if !found {
-		fmt.Println("✓ Get: temp -> 键不存在 (删除成功)")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("打开请求日志失败: %w", err)
 	}
-
-	// 示例 4: Scan 范围扫描
-	fmt.Println("\n【示例 4】Scan 范围扫描")
-	fmt.Println("--------------------------------------------------")
-
-	// 插入测试数据
-	for i := 1; i <= 5; i++ {
-		key := fmt.Sprintf("key%d", i)
-		value := fmt.Sprintf("value%d", i)
-		if err := client.Put("default", key, value); err != nil {
-			fmt.Printf("Put 错误: %v\n", err)
-		}
+	if j.f != nil {
+		j.f.Close()
 	}
-	fmt.Println("✓ 已插入 key1-key5")
+	j.f = f
+	j.completed = 0
+	j.synced = j.writes
+	return nil
+}
 
-	endKey := "key9"
-	results, err := client.Scan("default", "key1", &endKey, 10)
+// writeFileSync 写入文件并 fsync
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
 	if err != nil {
-		fmt.Printf("Scan 错误: %v\n", err)
-	} else {
-		fmt.Printf("✓ Scan 结果 (找到 %d 个键):\n", len(results))
-		for _, item := range results {
-			fmt.Printf("  %s = %s\n", item["key"], item["value"])
-		}
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
+}
 
-	// 示例 5: 服务器信息
-	fmt.Println("\n【示例 5】获取服务器信息")
-	fmt.Println("--------------------------------------------------")
+// pending 返回未完成的意图, 按序号排列
+func (j *requestJournal) pending() []JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
 
-	totalKeys, cfs, err := client.Info()
-	if err != nil {
-		fmt.Printf("Info 错误: %v\n", err)
-	} else {
-		fmt.Printf("✓ 服务器信息:\n")
-		fmt.Printf("  总键数: %d\n", totalKeys)
-		fmt.Printf("  列族: %v\n", cfs)
+	entries := make([]JournalEntry, 0, len(j.open))
+	for _, entry := range j.open {
+		entries = append(entries, entry)
 	}
+	sort.Slice(entries, func(a, b int) bool { return entries[a].Seq < entries[b].Seq })
+	return entries
+}
 
-	// 示例 6: 刷盘
-	fmt.Println("\n【示例 6】刷盘持久化")
-	fmt.Println("--------------------------------------------------")
+// close 将尚未落盘的完成记录 fsync 后关闭文件
+func (j *requestJournal) close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
 
-	if err := client.Flush(); err != nil {
-		fmt.Printf("Flush 错误: %v\n", err)
-	} else {
-		fmt.Println("✓ Flush: 数据已刷盘")
+	if j.f == nil {
+		return nil
 	}
+	err := j.syncLocked(j.writes)
+	if cerr := j.f.Close(); err == nil {
+		err = cerr
+	}
+	j.f = nil
+	return err
+}
+
+// InDoubtEntry 发出后没有确认结果的修改
+type InDoubtEntry struct {
+	JournalEntry
+	// MatchesServer 为 true 表示服务器上的当前状态与该操作的结果一致 (Put 的值哈希
+	// 相同, 或 Delete 的键不存在). 这只是线索: 可能是其他写入造成的, 也可能之后又被修改.
+	MatchesServer bool
+}
 
-	// 示例 7: 批量操作
-	fmt.Println("\n【示例 7】批量操作")
-	fmt.Println("--------------------------------------------------")
+// JournalReport RecoverJournal 的结果
+type JournalReport struct {
+	InDoubt []InDoubtEntry
+	// Truncated 打开日志时因尾部损坏截掉的字节数, 大于 0 时最后几个操作的记录已经丢失
+	Truncated int64
+}
 
-	users := map[string]string{
-		"user:1": "Alice",
-		"user:2": "Bob",
-		"user:3": "Charlie",
+// RecoverJournal 报告上次运行中发出后没有确认结果的修改, 供人工处理
+//
+// c 必须以 WithJournal(dir) 创建. 服务器不支持按幂等令牌去重 (见 src/server.rs),
+// 重发可能使同一操作执行两次, 因此这里不自动重发, 只读取每个键的当前状态作为
+// 线索. 处理完后调用 Client.ResolveJournalEntry, 否则下次启动时还会报告.
+func RecoverJournal(c *Client, dir string) (*JournalReport, error) {
+	if c.journal == nil || filepath.Clean(c.journal.dir) != filepath.Clean(dir) {
+		return nil, fmt.Errorf("%w: 客户端没有以 WithJournal(%q) 创建", ErrInvalidArgument, dir)
 	}
 
-	for key, value := range users {
-		if err := client.Put("default", key, value); err != nil {
-			fmt.Printf("批量 Put 错误: %v\n", err)
+	report := &JournalReport{Truncated: c.journal.truncated}
+	for _, entry := range c.journal.pending() {
+		value, found, err := c.Get(entry.CF, string(entry.Key))
+		if err != nil {
+			return report, fmt.Errorf("读取操作 %d 的键 %s 失败: %w", entry.Seq, formatKey(entry.Key), err)
 		}
-	}
-	fmt.Printf("✓ 批量写入 %d 条记录\n", len(users))
 
-	// 验证批量写入
-	for key := range users {
-		if value, found, err := client.Get("default", key); err != nil {
-			fmt.Printf("验证错误: %v\n", err)
-		} else if found {
-			fmt.Printf("  %s = %s\n", key, value)
+		matches := !found
+		if entry.Op == "Put" {
+			sum := sha256.Sum256([]byte(value))
+			matches = found && hex.EncodeToString(sum[:]) == entry.ValueHash
 		}
+		report.InDoubt = append(report.InDoubt, InDoubtEntry{JournalEntry: entry, MatchesServer: matches})
+	}
+	return report, nil
+}
+
+// ResolveJournalEntry 将人工确认过的操作标记为完成, 之后不再报告
+func (c *Client) ResolveJournalEntry(seq uint64) error {
+	if c.journal == nil {
+		return fmt.Errorf("%w: 客户端没有启用请求日志", ErrInvalidArgument)
+	}
+	if err := c.journal.complete(seq); err != nil {
+		return err
 	}
+
+	c.journal.mu.Lock()
+	defer c.journal.mu.Unlock()
+	return c.journal.syncLocked(c.journal.writes)
 }
@@ -0,0 +1,2437 @@
+package main
+
+// 运行: go test ./example/*.go
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// canonicalPut 规范化测试使用的命令, 包含需要转义和不需要 HTML 转义的字符
+var canonicalPut = Command{Type: "Put", CF: "a<b>&c", Key: []byte("k\x00é"), Value: []byte("v")}
+
+const canonicalPutGolden = `{"cf":"a<b>&c","key":"awDDqQ==","type":"Put","value":"dg=="}`
+
+func TestCanonicalCommandGolden(t *testing.T) {
+	limit := 0
+	endKey := []byte("z")
+	tests := []struct {
+		cmd  Command
+		want string
+	}{
+		{canonicalPut, canonicalPutGolden},
+		{
+			Command{Type: "Scan", CF: "default", StartKey: []byte("a"), EndKey: &endKey, Limit: &limit},
+			`{"cf":"default","end_key":"eg==","limit":0,"start_key":"YQ==","type":"Scan"}`,
+		},
+		{Command{Type: "Info"}, `{"type":"Info"}`},
+	}
+
+	for _, tt := range tests {
+		got, err := CanonicalCommand(tt.cmd)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.cmd.Type, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s:\n got  %s\n want %s", tt.cmd.Type, got, tt.want)
+		}
+	}
+}
+
+func TestCanonicalCommandIgnoresFieldOrderAndSignature(t *testing.T) {
+	inputs := []string{
+		`{"type":"Put","cf":"a<b>&c","key":"awDDqQ==","value":"dg=="}`,
+		`{"value":"dg==","key":"awDDqQ==","cf":"a\u003cb\u003e\u0026c","type":"Put"}`,
+		`{"signature":{"key_id":"x","alg":"HS256","sig":"AA=="},"cf":"a<b>&c","type":"Put","value":"dg==","key":"awDDqQ=="}`,
+	}
+
+	for _, in := range inputs {
+		var cmd Command
+		if err := json.Unmarshal([]byte(in), &cmd); err != nil {
+			t.Fatal(err)
+		}
+		got, err := CanonicalCommand(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != canonicalPutGolden {
+			t.Errorf("%s:\n got  %s\n want %s", in, got, canonicalPutGolden)
+		}
+	}
+}
+
+func TestSignVerifyRoundTrip(t *testing.T) {
+	edKey := ed25519.NewKeyFromSeed(make([]byte, 32))
+	tests := []struct {
+		name    string
+		signer  Signer
+		wantSig string
+	}{
+		{
+			"HS256",
+			HMACSigner{KeyID: "k1", Key: []byte("secret")},
+			"f36cb0a0cb40009b42c6c2a0337427de346b0bcadd7dd272c9de83de8668243f",
+		},
+		{
+			"Ed25519",
+			Ed25519Signer{KeyID: "e1", Key: edKey},
+			"76904b9989909ebbf408f71fa33310381e9557727a755e0993cbc64ff4160914" +
+				"b95781689fe2c6a3ffefe07c63b03090b32b98f0fd93ee13d6d9f45475800806",
+		},
+	}
+	verifier := KeyVerifier{
+		HMACKeys:    map[string][]byte{"k1": []byte("secret")},
+		Ed25519Keys: map[string]ed25519.PublicKey{"e1": edKey.Public().(ed25519.PublicKey)},
+	}
+
+	for _, tt := range tests {
+		cmd := canonicalPut
+		if err := signCommand(tt.signer, &cmd); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := hex.EncodeToString(cmd.Signature.Sig); got != tt.wantSig {
+			t.Errorf("%s: 签名 %s, 期望 %s", tt.name, got, tt.wantSig)
+		}
+
+		// 经过一次序列化, 模拟服务器或审计工具读到的命令
+		data, err := json.Marshal(cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var received Command
+		if err := json.Unmarshal(data, &received); err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyCommand(verifier, received); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+
+		received.Value = []byte("tampered")
+		if err := VerifyCommand(verifier, received); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("%s: 篡改后的命令: %v", tt.name, err)
+		}
+	}
+}
+
+// dialectGolden 每种方言的响应样例及解码结果
+var dialectGolden = []struct {
+	name      string
+	dialect   WireDialect
+	put       string // Put{cf: "default", key: "k", value: "hi"} 的编码
+	responses map[string]Response
+}{
+	{
+		"canonical",
+		CanonicalDialect,
+		`{"type":"Put","cf":"default","key":[107],"value":[104,105]}`,
+		map[string]Response{
+			`{"type":"Ok"}`:                     {},
+			`{"type":"Value","data":[104,105]}`: {Value: "hi"},
+			`{"type":"Value","data":null}`:      {},
+			`{"type":"Values","data":[[[97],[49]],[[98],[]]]}`: {Values: []interface{}{
+				[]interface{}{"a", "1"}, []interface{}{"b", ""},
+			}},
+			`{"type":"Error","data":"boom"}`: {Error: "boom"},
+			`{"type":"Info","data":{"total_keys":3,"column_families":["default"]}}`: {Info: map[string]interface{}{
+				"total_keys": float64(3), "column_families": []interface{}{"default"},
+			}},
+		},
+	},
+	{
+		"flat",
+		FlatDialect,
+		`{"type":"Put","cf":"default","key":"k","value":"hi"}`,
+		map[string]Response{
+			`{}`:               {},
+			`{"Value":"hi"}`:   {Value: "hi"},
+			`{"value":"hi"}`:   {Value: "hi"},
+			`{"Error":"boom"}`: {Error: "boom"},
+			`{"Values":[["a","1"]]}`: {Values: []interface{}{
+				[]interface{}{"a", "1"},
+			}},
+		},
+	},
+	{
+		"short",
+		ShortDialect,
+		`{"type":"Put","cf":"default","key":"aw==","value":"aGk="}`,
+		map[string]Response{
+			`{"Val":"aGk="}`:               {Value: "hi"},
+			`{"Val":"aGk=","Found":false}`: {},
+			`{"Err":"boom"}`:               {Error: "boom"},
+			`{"Vals":[["YQ==","MQ=="]]}`: {Values: []interface{}{
+				[]interface{}{"a", "1"},
+			}},
+		},
+	},
+}
+
+func TestDialectGolden(t *testing.T) {
+	for _, tt := range dialectGolden {
+		if err := tt.dialect.validate(); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		data, err := tt.dialect.marshalCommand(Command{Type: "Put", CF: "default", Key: []byte("k"), Value: []byte("hi")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != tt.put {
+			t.Errorf("%s: 命令编码\n got  %s\n want %s", tt.name, data, tt.put)
+		}
+
+		for raw, want := range tt.responses {
+			got, err := tt.dialect.decode([]byte(raw))
+			if err != nil {
+				t.Errorf("%s: %s: %v", tt.name, raw, err)
+				continue
+			}
+			if !reflect.DeepEqual(*got, want) {
+				t.Errorf("%s: %s:\n got  %#v\n want %#v", tt.name, raw, *got, want)
+			}
+		}
+	}
+}
+
+func TestDialectRejectsMalformed(t *testing.T) {
+	bad := []string{
+		`{"data":[1]}`,
+		`{"type":"Value","data":[256]}`,
+		`{"type":"Value","data":"aGk="}`,
+		`[1,2]`,
+	}
+	for _, raw := range bad {
+		if _, err := CanonicalDialect.decode([]byte(raw)); err == nil {
+			t.Errorf("%s: 期望解析失败", raw)
+		}
+	}
+
+	if err := (WireDialect{Tag: "type", Value: "V", Values: "Vs", Info: "I", Error: "E"}).validate(); !errors.Is(err, ErrInvalidArgument) {
+		t.Errorf("只设置 Tag: %v", err)
+	}
+}
+
+// fakeServer 按 src/server.rs 和 src/common.rs 的行为模拟服务器: 每次读取一个最多
+// 8192 字节的命令, 缺少字段或无法解析时关闭连接, 响应是 tag/content 信封和字节数组
+type fakeServer struct {
+	t  *testing.T
+	ln net.Listener
+
+	mu       sync.Mutex
+	data     map[string][]byte // 列族 + "_" + 键 -> 值
+	commands []map[string]json.RawMessage
+	// hook 返回非 nil 时代替默认处理, 返回 fakeHang 时不响应
+	hook func(cmd map[string]json.RawMessage) []byte
+}
+
+// fakeHang 由 hook 返回, 表示不响应该命令
+var fakeHang = []byte("hang")
+
+// fakeCrash 由 hook 返回, 表示不响应并断开连接, 相当于服务器在这一步崩溃
+var fakeCrash = []byte("crash")
+
+func newFakeServer(t *testing.T) *fakeServer {
+	s, err := startFakeServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.t = t
+	t.Cleanup(s.close)
+	return s
+}
+
+// startFakeServer 启动不属于任何测试的模拟服务器, 供 Example 函数使用, 需自行 close
+func startFakeServer() (*fakeServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &fakeServer{ln: ln, data: map[string][]byte{}}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, nil
+}
+
+// close 停止接受新连接, 已有连接在客户端关闭时结束
+func (s *fakeServer) close() { s.ln.Close() }
+
+func (s *fakeServer) addr() string { return s.ln.Addr().String() }
+
+// put 直接写入数据, 不经过客户端
+func (s *fakeServer) put(cf, key, value string) {
+	s.mu.Lock()
+	s.data[cf+"_"+key] = []byte(value)
+	s.mu.Unlock()
+}
+
+func (s *fakeServer) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.data)
+}
+
+// received 返回收到的命令中 type 为 cmdType 的部分
+func (s *fakeServer) received(cmdType string) []map[string]json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cmds []map[string]json.RawMessage
+	for _, cmd := range s.commands {
+		if string(cmd["type"]) == `"`+cmdType+`"` {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+func (s *fakeServer) serve(conn net.Conn) {
+	defer conn.Close()
+
+	buffer := make([]byte, 8192)
+	for {
+		n, err := conn.Read(buffer)
+		if err != nil || n == 0 {
+			return
+		}
+
+		var cmd map[string]json.RawMessage
+		if err := json.Unmarshal(buffer[:n], &cmd); err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, cmd)
+		hook := s.hook
+		s.mu.Unlock()
+
+		var resp []byte
+		if hook != nil {
+			resp = hook(cmd)
+		}
+		if resp == nil {
+			if resp, err = s.handle(cmd); err != nil {
+				return
+			}
+		}
+		if bytes.Equal(resp, fakeHang) {
+			continue
+		}
+		if bytes.Equal(resp, fakeCrash) {
+			return
+		}
+		if _, err := conn.Write(resp); err != nil {
+			return
+		}
+	}
+}
+
+// handle 按服务器的规则处理命令; 返回错误表示服务器会关闭连接
+func (s *fakeServer) handle(cmd map[string]json.RawMessage) ([]byte, error) {
+	var typ string
+	if err := json.Unmarshal(cmd["type"], &typ); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch typ {
+	case "Info":
+		cfs := map[string]bool{}
+		for k := range s.data {
+			cfs[k[:strings.IndexByte(k, '_')]] = true
+		}
+		list := make([]string, 0, len(cfs))
+		for cf := range cfs {
+			list = append(list, cf)
+		}
+		sort.Strings(list)
+		return fakeResponse("Info", map[string]interface{}{"total_keys": len(s.data), "column_families": list}), nil
+	case "Flush", "Compact":
+		return fakeResponse("Ok", nil), nil
+	}
+
+	var cf string
+	if err := fakeField(cmd, "cf", &cf); err != nil {
+		return nil, err
+	}
+
+	switch typ {
+	case "Get", "Put", "Delete":
+		key, err := fakeBytes(cmd, "key")
+		if err != nil {
+			return nil, err
+		}
+		full := cf + "_" + string(key)
+		switch typ {
+		case "Get":
+			if v, ok := s.data[full]; ok {
+				return fakeResponse("Value", fakeArray(v)), nil
+			}
+			return fakeResponse("Value", nil), nil
+		case "Put":
+			value, err := fakeBytes(cmd, "value")
+			if err != nil {
+				return nil, err
+			}
+			s.data[full] = value
+		default:
+			delete(s.data, full)
+		}
+		return fakeResponse("Ok", nil), nil
+	case "Scan":
+		start, err := fakeBytes(cmd, "start_key")
+		if err != nil {
+			return nil, err
+		}
+		var end *string
+		if raw, ok := cmd["end_key"]; !ok {
+			return nil, errors.New("缺少 end_key")
+		} else if string(raw) != "null" {
+			b, err := fakeBytes(cmd, "end_key")
+			if err != nil {
+				return nil, err
+			}
+			e := cf + "_" + string(b)
+			end = &e
+		}
+		var limit int
+		if err := fakeField(cmd, "limit", &limit); err != nil {
+			return nil, err
+		}
+
+		keys := make([]string, 0, len(s.data))
+		for k := range s.data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := []interface{}{}
+		for _, k := range keys {
+			if k < cf+"_"+string(start) || !strings.HasPrefix(k, cf+"_") {
+				continue
+			}
+			if end != nil && k >= *end {
+				break
+			}
+			items = append(items, []interface{}{fakeArray([]byte(k[len(cf)+1:])), fakeArray(s.data[k])})
+			if limit > 0 && len(items) >= limit {
+				break
+			}
+		}
+		return fakeResponse("Values", items), nil
+	}
+	return nil, fmt.Errorf("未知命令 %s", typ)
+}
+
+// fakeField 读取必需的字段, 缺少时返回错误
+func fakeField(cmd map[string]json.RawMessage, name string, dst interface{}) error {
+	raw, ok := cmd[name]
+	if !ok {
+		return fmt.Errorf("缺少 %s", name)
+	}
+	return json.Unmarshal(raw, dst)
+}
+
+// fakeBytes 按 serde_bytes 的规则读取字节: 字节数组, 或字符串的 UTF-8 字节
+func fakeBytes(cmd map[string]json.RawMessage, name string) ([]byte, error) {
+	var arr []byte
+	var nums []int
+	if err := fakeField(cmd, name, &nums); err == nil {
+		for _, n := range nums {
+			arr = append(arr, byte(n))
+		}
+		return arr, nil
+	}
+	var str string
+	if err := fakeField(cmd, name, &str); err != nil {
+		return nil, err
+	}
+	return []byte(str), nil
+}
+
+// fakeArray 字节数组, 避免 encoding/json 把 []byte 编码为 Base64
+func fakeArray(b []byte) []int {
+	arr := make([]int, len(b))
+	for i, x := range b {
+		arr[i] = int(x)
+	}
+	return arr
+}
+
+func fakeResponse(variant string, data interface{}) []byte {
+	resp := map[string]interface{}{"type": variant}
+	if variant != "Ok" {
+		resp["data"] = data
+	}
+	b, _ := json.Marshal(resp)
+	return b
+}
+
+// newTestClient 连接 s, 测试结束时关闭
+func newTestClient(t *testing.T, s *fakeServer, opts ...Option) *Client {
+	c, err := NewClient(s.addr(), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func TestWireCommandsCarryRequiredFields(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDefaultCF("main"))
+
+	if err := c.Put("", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := c.Get("", "k"); err != nil || !ok || v != "v" {
+		t.Fatalf("Get: %q %v %v", v, ok, err)
+	}
+	if _, err := c.ScanItems("", "", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("", "k"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, typ := range []string{"Put", "Get", "Scan", "Delete"} {
+		cmds := s.received(typ)
+		if len(cmds) != 1 {
+			t.Fatalf("%s: 收到 %d 个命令", typ, len(cmds))
+		}
+		if cf := string(cmds[0]["cf"]); cf != `"main"` {
+			t.Errorf("%s: cf = %s", typ, cf)
+		}
+	}
+	scan := s.received("Scan")[0]
+	for _, field := range []string{"start_key", "end_key", "limit"} {
+		if _, ok := scan[field]; !ok {
+			t.Errorf("Scan 缺少 %s: %v", field, scan)
+		}
+	}
+}
+
+func TestMigrateEmptyCF(t *testing.T) {
+	s := newFakeServer(t)
+	s.put("", "a", "1")
+	s.put("", "b", "2")
+	s.put("default", "c", "3")
+	c := newTestClient(t, s)
+
+	copied, err := c.MigrateEmptyCF(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 2 {
+		t.Fatalf("复制了 %d 个键", copied)
+	}
+	for _, cmd := range s.received("Scan") {
+		if cf := string(cmd["cf"]); cf != `""` {
+			t.Errorf("扫描的列族为 %s", cf)
+		}
+	}
+
+	// 连接仍然可用
+	items, err := c.ScanItems("default", "", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 3 {
+		t.Fatalf("default 中有 %d 个键: %v", len(items), items)
+	}
+}
+
+// TestExamples 对模拟服务器逐个运行示例程序, CI 中还会对真实服务器运行一次
+func TestExamples(t *testing.T) {
+	for _, name := range exampleNames() {
+		t.Run(name, func(t *testing.T) {
+			s := newFakeServer(t)
+			if err := runExamplesWith(s.addr(), []Option{WithDebugOutput(io.Discard)}, name); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	// 所有示例依次运行在同一个服务器上, 与 CI 中的用法相同
+	s := newFakeServer(t)
+	if err := runExamplesWith(s.addr(), []Option{WithDebugOutput(io.Discard)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runCommand(s.addr(), nil, []string{"examples", "missing"}); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("未知示例: %v", err)
+	}
+}
+
+func TestOversizedResponseRedials(t *testing.T) {
+	s := newFakeServer(t)
+	s.put("default", "small", "ok")
+	s.hook = func(cmd map[string]json.RawMessage) []byte {
+		if key, _ := fakeBytes(cmd, "key"); string(key) == "big" {
+			return fakeResponse("Value", fakeArray(bytes.Repeat([]byte("x"), 4000)))
+		}
+		return nil
+	}
+	c := newTestClient(t, s)
+
+	if _, _, err := c.Get("default", "big"); !errors.Is(err, ErrFrame) {
+		t.Fatalf("超过单帧的响应: err = %v", err)
+	}
+	if got := c.Reconnects().Error; got != 1 {
+		t.Fatalf("重建连接 %d 次", got)
+	}
+	// 截断响应的剩余部分不能被下一个请求读到
+	if v, ok, err := c.Get("default", "small"); err != nil || !ok || v != "ok" {
+		t.Fatalf("Get: %q %v %v", v, ok, err)
+	}
+}
+
+// TestEstimateCommandSizeMatchesWire 估算值与实际序列化结果的偏差: 除字符串中的
+// JSON 转义外必须为 0, escapes 是各用例中转义增加的字节数
+func TestEstimateCommandSizeMatchesWire(t *testing.T) {
+	end := []byte("z\xff")
+	var noEnd []byte
+	limit := 100
+	cmds := []struct {
+		cmd     Command
+		escapes int
+	}{
+		{Command{Type: "Info"}, 0},
+		{Command{Type: "Get", CF: "users", Key: []byte("user:1")}, 0},
+		{Command{Type: "Get"}, 0},
+		{Command{Type: "Put", CF: "default", Key: []byte("k"), Value: []byte{0, 9, 10, 99, 100, 255}}, 0},
+		{Command{Type: "Put", CF: "default", Key: []byte("k")}, 0},
+		{Command{Type: "Delete", CF: "", Key: []byte("k")}, 0},
+		{Command{Type: "Scan", CF: "default"}, 0},
+		{Command{Type: "Scan", CF: "default", StartKey: []byte("a"), EndKey: &noEnd, Limit: &limit}, 0},
+		{Command{Type: "Scan", CF: "default", StartKey: []byte("a"), EndKey: &end}, 0},
+		{Command{Type: "Put", CF: "a<b", Key: []byte("k"), Value: []byte("v"),
+			Signature: &Signature{KeyID: "key-1", Alg: "hmac-sha256", Sig: make([]byte, 32)}}, len(`\u003c`) - len(`<`)},
+	}
+
+	for _, d := range []WireDialect{CanonicalDialect, FlatDialect, ShortDialect} {
+		for _, tc := range cmds {
+			data, err := d.marshalCommand(tc.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			escapes := tc.escapes
+			if d.Encoding == ValueEncodingString {
+				// 字符串编码时值中的 \xff 变为 �
+				escapes += len(data) - len(mustMarshalStringValues(t, d, tc.cmd))
+			}
+			if got := EstimateCommandSize(tc.cmd, d); got+escapes != len(data) {
+				t.Errorf("%+v %s: 估算 %d, 实际 %d (转义 %d): %s", d, tc.cmd.Type, got, len(data), escapes, data)
+			}
+		}
+	}
+}
+
+// mustMarshalStringValues 序列化 cmd, 但把键和值中的非 ASCII 字节换为 'x', 使字符串编码不产生转义
+func mustMarshalStringValues(t *testing.T, d WireDialect, cmd Command) []byte {
+	t.Helper()
+	ascii := func(b []byte) []byte {
+		if b == nil {
+			return nil
+		}
+		out := make([]byte, len(b))
+		for i, x := range b {
+			if x < 0x20 || x >= 0x7f || x == '"' || x == '\\' || x == '<' || x == '>' || x == '&' {
+				x = 'x'
+			}
+			out[i] = x
+		}
+		return out
+	}
+	cmd.Key, cmd.Value, cmd.StartKey = ascii(cmd.Key), ascii(cmd.Value), ascii(cmd.StartKey)
+	if cmd.EndKey != nil {
+		end := ascii(*cmd.EndKey)
+		cmd.EndKey = &end
+	}
+	data, err := d.marshalCommand(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// scanLimits 返回 s 收到的每个 Scan 命令的 limit
+func (s *fakeServer) scanLimits() []int {
+	var limits []int
+	for _, cmd := range s.received("Scan") {
+		var limit int
+		json.Unmarshal(cmd["limit"], &limit)
+		limits = append(limits, limit)
+	}
+	return limits
+}
+
+// TestScanPageProbeWithinCap 两种上限下, 探测的一条都必须在上限之内, 截断的页不能被当作最后一页
+// TestScanLimitOnTheWire 检查各种配置下实际发送的 limit
+func TestScanLimitOnTheWire(t *testing.T) {
+	cases := []struct {
+		name   string
+		opts   []Option
+		limits []int // 依次传给 ScanItems
+		want   []int // 线上的 limit
+	}{
+		{"不限制", nil, []int{0, 1, 7}, []int{0, 1, 7}},
+		{"默认值", []Option{WithDefaultScanLimit(5)}, []int{0, 1, 7}, []int{5, 1, 7}},
+		{"收紧到上限", []Option{WithMaxScanLimit(3)}, []int{0, 1, 3, 10}, []int{3, 1, 3, 3}},
+		{"默认值超过上限", []Option{WithDefaultScanLimit(10), WithMaxScanLimit(3)}, []int{0, 2}, []int{3, 2}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newFakeServer(t)
+			c := newTestClient(t, s, append(tc.opts, WithDebugOutput(io.Discard))...)
+			for _, limit := range tc.limits {
+				if _, err := c.ScanItems("default", "", nil, limit); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := s.scanLimits(); !reflect.DeepEqual(got, tc.want) {
+				t.Fatalf("线上的 limit %v, 期望 %v", got, tc.want)
+			}
+		})
+	}
+
+	s := newFakeServer(t)
+	for _, opt := range []Option{WithDefaultScanLimit(-3), WithMaxScanLimit(-1)} {
+		if _, err := NewClient(s.addr(), opt); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("负数的 limit 配置: err = %v", err)
+		}
+	}
+	if got := s.scanLimits(); len(got) != 0 {
+		t.Fatalf("不应发送 Scan: %v", got)
+	}
+}
+
+func TestScanPageProbeWithinCap(t *testing.T) {
+	caps := map[string]func(c *Client){
+		"WithMaxScanLimit": nil,
+		"SetMaxScanLimit":  func(c *Client) { c.Controls().SetMaxScanLimit(2) },
+	}
+	for name, setCap := range caps {
+		t.Run(name, func(t *testing.T) {
+			s := newFakeServer(t)
+			for _, k := range []string{"a", "b", "c", "d", "e"} {
+				s.put("default", k, k)
+			}
+			var c *Client
+			if setCap == nil {
+				c = newTestClient(t, s, WithMaxScanLimit(2))
+			} else {
+				c = newTestClient(t, s)
+				setCap(c)
+			}
+
+			page, err := c.ScanPage("default", "", nil, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Items) != 1 || !page.HasMore || page.NextCursor != "b" {
+				t.Fatalf("第一页: %+v", page)
+			}
+
+			var keys []string
+			cursor := ""
+			for {
+				page, err := c.ScanPage("default", cursor, nil, 2)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, item := range page.Items {
+					keys = append(keys, item.Key)
+				}
+				if !page.HasMore {
+					break
+				}
+				cursor = page.NextCursor
+			}
+			if got := strings.Join(keys, ""); got != "abcde" {
+				t.Fatalf("翻页得到 %q", got)
+			}
+			for _, limit := range s.scanLimits() {
+				if limit > 2 {
+					t.Fatalf("发送的 limit %d 超过上限: %v", limit, s.scanLimits())
+				}
+			}
+		})
+	}
+}
+
+func TestScanPageRejectsCapLoweredInFlight(t *testing.T) {
+	ctl := newControls(realClock{}, context.Background())
+	ctl.SetMaxScanLimit(2)
+
+	limit := 3
+	cmd := Command{Type: "Scan", Limit: &limit, exactLimit: true}
+	if err := ctl.admit(&cmd); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("admit: %v", err)
+	}
+	if *cmd.Limit != 3 {
+		t.Fatalf("limit 被收紧为 %d", *cmd.Limit)
+	}
+}
+
+func TestScanPageStrictCapReservesProbe(t *testing.T) {
+	s := newFakeServer(t)
+	for _, k := range []string{"a", "b", "c"} {
+		s.put("default", k, k)
+	}
+	c := newTestClient(t, s, WithMaxScanLimit(2), WithStrictScanLimit())
+
+	if _, err := c.ScanPage("default", "", nil, 2); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("limit 等于上限: %v", err)
+	}
+	if n := len(s.received("Scan")); n != 0 {
+		t.Fatalf("被拒绝的分页仍发送了 %d 个 Scan", n)
+	}
+
+	page, err := c.ScanPage("default", "", nil, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || !page.HasMore {
+		t.Fatalf("page: %+v", page)
+	}
+	if limits := s.scanLimits(); !reflect.DeepEqual(limits, []int{2}) {
+		t.Fatalf("发送的 limit: %v", limits)
+	}
+}
+
+func TestDeleteRangeUnderScanCap(t *testing.T) {
+	s := newFakeServer(t)
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		s.put("default", k, k)
+	}
+	c := newTestClient(t, s)
+	c.Controls().SetMaxScanLimit(2)
+
+	end := "z"
+	result, err := c.DeleteRange(context.Background(), "default", "a", &end, WithDeletePageSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Deleted != 5 || result.Cursor != "" || s.len() != 0 {
+		t.Fatalf("删除 %d 个, Cursor %q, 剩余 %d 个", result.Deleted, result.Cursor, s.len())
+	}
+
+	// 上限无法容纳一页加探测时不能报告完成
+	s.put("default", "f", "f")
+	c.Controls().SetMaxScanLimit(1)
+	result, err = c.DeleteRange(context.Background(), "default", "a", &end)
+	if !errors.Is(err, ErrInvalidArgument) || result.Cursor != "a" {
+		t.Fatalf("err = %v, result = %+v", err, result)
+	}
+}
+
+func TestCloseInterruptsHungRequest(t *testing.T) {
+	s := newFakeServer(t)
+	started := make(chan struct{})
+	s.hook = func(cmd map[string]json.RawMessage) []byte {
+		if string(cmd["type"]) == `"Get"` {
+			close(started)
+			return fakeHang
+		}
+		return nil
+	}
+	c := newTestClient(t, s)
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := c.Get("default", "k")
+		done <- err
+	}()
+	<-started
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close 等待了挂起的请求")
+	}
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("挂起的 Get: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close 没有中断挂起的 Get")
+	}
+
+	if _, _, err := c.Get("default", "k"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("关闭后的 Get: %v", err)
+	}
+}
+
+func TestMutationsInvalidateInfoCache(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithMetadataCacheTTL(time.Hour))
+
+	total := func() int {
+		t.Helper()
+		info, err := c.InfoDetailed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.TotalKeys
+	}
+
+	if n := total(); n != 0 {
+		t.Fatalf("初始键数 %d", n)
+	}
+	if err := c.Put("default", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if n := total(); n != 1 {
+		t.Fatalf("Put 后键数 %d", n)
+	}
+	if err := c.Delete("default", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if n := total(); n != 0 {
+		t.Fatalf("Delete 后键数 %d", n)
+	}
+	if _, _, err := c.Get("default", "k"); err != nil {
+		t.Fatal(err)
+	}
+	total()
+	if n := len(s.received("Info")); n != 3+1 { // 加上连接检查
+		t.Fatalf("发送了 %d 次 Info, 只读命令不应使缓存失效", n)
+	}
+}
+
+func TestValueSizeWatcherIsBounded(t *testing.T) {
+	if got := keyPrefix([]byte("user:42")); got != `"user:"` {
+		t.Fatalf("keyPrefix = %s", got)
+	}
+	if got := keyPrefix([]byte("0123456789abcdef-without-separator")); got != `"0123456789abcdef"` {
+		t.Fatalf("没有 ':' 的键: keyPrefix = %s", got)
+	}
+
+	clock := NewFakeClock(time.Unix(0, 0))
+	w := newValueSizeWatcher("", 1, func(ValueSizeWarning) {}, clock)
+	defer w.stop()
+
+	for i := 0; i < 3*valueSizeMaxBuckets; i++ {
+		w.check("default", []byte(fmt.Sprintf("%08d", i)), 2)
+	}
+	w.mu.Lock()
+	counts, warned, other := len(w.counts), len(w.lastWarned), w.counts["default/"+valueSizeOtherPrefix]
+	w.mu.Unlock()
+	if counts != valueSizeMaxBuckets+1 || warned != valueSizeMaxBuckets {
+		t.Fatalf("counts %d 项, lastWarned %d 项", counts, warned)
+	}
+	if other != 2*valueSizeMaxBuckets {
+		t.Fatalf("归入 %s 的次数 %d", valueSizeOtherPrefix, other)
+	}
+
+	// 限流间隔过后旧记录被淘汰, 新前缀可以再次告警
+	clock.Advance(valueSizeWarningInterval)
+	w.check("default", []byte("new:1"), 2)
+	w.mu.Lock()
+	_, ok := w.lastWarned["default/"+`"new:"`]
+	warned = len(w.lastWarned)
+	w.mu.Unlock()
+	if !ok || warned != 1 {
+		t.Fatalf("淘汰后 lastWarned %d 项, 包含新前缀: %v", warned, ok)
+	}
+}
+
+func TestValueSizePercentilesPerPrefix(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithValueSizeWarning(500, func(ValueSizeWarning) {}))
+
+	// user: 前缀 100 个值, 第 i 个 i 字节; blob: 前缀少数几个大值
+	for i := 1; i <= 100; i++ {
+		if err := c.Put("default", fmt.Sprintf("user:%d", i), strings.Repeat("x", i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, n := range []int{1000, 1000, 2000} {
+		if err := c.Put("default", "blob:1", strings.Repeat("x", n)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := c.ValueSizePercentiles()
+	if len(stats) != 2 || stats[0].Prefix != `default/"blob:"` || stats[1].Prefix != `default/"user:"` {
+		t.Fatalf("%+v", stats)
+	}
+	blob, user := stats[0], stats[1]
+	if blob.Count != 3 || blob.Max != 2000 || blob.P99 != 2000 || blob.P50 < 1000 || blob.P50 > 1000*119/100 {
+		t.Errorf("blob: %+v", blob)
+	}
+	// 百分位为所在桶的上界, 误差不超过一个桶
+	within := func(got, want int) bool { return got >= want && got <= want*119/100+1 }
+	if user.Count != 100 || user.Max != 100 || !within(user.P50, 50) || !within(user.P90, 90) || !within(user.P99, 99) {
+		t.Errorf("user: %+v", user)
+	}
+
+	// 未超过阈值的值同样计入分布, 但不计入告警次数
+	warned, _ := c.ValueSizeWarnings()
+	if warned[`default/"user:"`] != 0 || warned[`default/"blob:"`] != 3 {
+		t.Errorf("告警次数 %v", warned)
+	}
+
+	if stats := newTestClient(t, s).ValueSizePercentiles(); stats != nil {
+		t.Errorf("未启用时 %+v", stats)
+	}
+}
+
+// setHook 在服务器运行期间替换 hook
+func (s *fakeServer) setHook(hook func(cmd map[string]json.RawMessage) []byte) {
+	s.mu.Lock()
+	s.hook = hook
+	s.mu.Unlock()
+}
+
+// crashOn 让 s 在收到 key 的修改时崩溃; applied 为 true 时先执行再崩溃
+func (s *fakeServer) crashOn(key string, applied bool) {
+	s.setHook(func(cmd map[string]json.RawMessage) []byte {
+		if k, _ := fakeBytes(cmd, "key"); string(k) != key || string(cmd["type"]) == `"Get"` {
+			return nil
+		}
+		if applied {
+			if _, err := s.handle(cmd); err != nil {
+				s.t.Error(err)
+			}
+		}
+		return fakeCrash
+	})
+}
+
+func TestJournalReportsInDoubtMutations(t *testing.T) {
+	dir := t.TempDir()
+	s := newFakeServer(t)
+	s.put("default", "gone", "x")
+
+	c := newTestClient(t, s, WithJournal(dir))
+	if err := c.Put("default", "done", "v"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 服务器在执行前崩溃
+	s.crashOn("lost", false)
+	if err := c.Put("default", "lost", "v"); err == nil {
+		t.Fatal("Put 应当失败")
+	}
+	c.Close()
+
+	// 服务器执行后、响应前崩溃
+	s.crashOn("applied", true)
+	c = newTestClient(t, s, WithJournal(dir))
+	if err := c.Put("default", "applied", "v"); err == nil {
+		t.Fatal("Put 应当失败")
+	}
+	c.Close()
+
+	s.crashOn("gone", true)
+	c = newTestClient(t, s, WithJournal(dir))
+	if err := c.Delete("default", "gone"); err == nil {
+		t.Fatal("Delete 应当失败")
+	}
+	c.Close()
+
+	s.setHook(nil)
+	c = newTestClient(t, s, WithJournal(dir))
+	report, err := RecoverJournal(c, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for _, entry := range report.InDoubt {
+		got[entry.Op+" "+string(entry.Key)] = entry.MatchesServer
+		if entry.Token == "" || entry.CF != "default" {
+			t.Errorf("记录不完整: %+v", entry)
+		}
+	}
+	want := map[string]bool{"Put lost": false, "Put applied": true, "Delete gone": true}
+	if !reflect.DeepEqual(got, want) || report.Truncated != 0 {
+		t.Fatalf("report: %v, truncated %d", got, report.Truncated)
+	}
+
+	// 人工处理后不再报告
+	for _, entry := range report.InDoubt {
+		if err := c.ResolveJournalEntry(entry.Seq); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Close()
+	c = newTestClient(t, s, WithJournal(dir))
+	if report, err := RecoverJournal(c, dir); err != nil || len(report.InDoubt) != 0 {
+		t.Fatalf("处理后: %+v %v", report, err)
+	}
+}
+
+// TestJournalCompletesUnsentAndRecordsFailures 本地拒绝的命令不算存疑; 完成记录写入失败进入错误历史
+func TestJournalCompletesUnsentAndRecordsFailures(t *testing.T) {
+	dir := t.TempDir()
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithJournal(dir))
+
+	// 超过单帧上限, 在写入连接之前被拒绝
+	if err := c.Put("default", "big", strings.Repeat("v", maxFrameSize)); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("err = %v", err)
+	}
+	if pending := c.journal.pending(); len(pending) != 0 {
+		t.Fatalf("未发出的命令留在日志中: %+v", pending)
+	}
+
+	// 服务器处理期间日志被关闭, 操作本身成功
+	s.setHook(func(cmd map[string]json.RawMessage) []byte {
+		c.journal.close()
+		return nil
+	})
+	if err := c.Put("default", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	errs := c.RecentErrors()
+	if len(errs) == 0 || errs[len(errs)-1].Class != ErrorClassJournal || errs[len(errs)-1].Command != "Put" {
+		t.Fatalf("错误历史: %+v", errs)
+	}
+}
+
+func TestJournalTruncatesCorruptTail(t *testing.T) {
+	dir := t.TempDir()
+	s := newFakeServer(t)
+	s.crashOn("lost", false)
+
+	c := newTestClient(t, s, WithJournal(dir))
+	c.Put("default", "lost", "v")
+	c.Close()
+
+	// 崩溃时写到一半的记录, 以及校验和不符的记录
+	path := dir + "/" + journalFile
+	valid, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := append(append([]byte{}, valid...), "00000000 {\"complete\":1}\n0badc0de {\"intent\":"...)
+	if err := os.WriteFile(path, corrupt, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	s.setHook(nil)
+	c = newTestClient(t, s, WithJournal(dir))
+	report, err := RecoverJournal(c, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Truncated != int64(len(corrupt)-len(valid)) {
+		t.Fatalf("截掉 %d 字节", report.Truncated)
+	}
+	if len(report.InDoubt) != 1 || string(report.InDoubt[0].Key) != "lost" {
+		t.Fatalf("InDoubt: %+v", report.InDoubt)
+	}
+
+	// 截断后可以继续追加
+	if err := c.Put("default", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJournalCompactsCompletedEntries(t *testing.T) {
+	dir := t.TempDir()
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithJournal(dir))
+
+	for i := 0; i < journalCompactEvery+10; i++ {
+		if err := c.Put("default", fmt.Sprintf("k%d", i), "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	info, err := os.Stat(dir + "/" + journalFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 压缩后只剩最近 10 个操作的意图和完成记录
+	if info.Size() > 20*512 {
+		t.Fatalf("日志 %d 字节, 没有压缩", info.Size())
+	}
+	if report, err := RecoverJournal(c, dir); err != nil || len(report.InDoubt) != 0 {
+		t.Fatalf("%+v %v", report, err)
+	}
+}
+
+func TestLatencyBucketBoundaries(t *testing.T) {
+	for _, resolution := range []int{1, defaultLatencyResolution, maxLatencyResolution} {
+		r := newLatencyRecorder("", resolution)
+		if got := r.bucket(0); got != 0 {
+			t.Fatalf("r=%d: bucket(0) = %d", resolution, got)
+		}
+		if got := r.bucket(latencyBase - 1); got != 0 {
+			t.Fatalf("r=%d: bucket(latencyBase-1) = %d", resolution, got)
+		}
+		for i, bound := range r.bounds {
+			// 上界属于下一个桶, 上界减 1ns 仍在本桶
+			if got := r.bucket(bound); got != i+1 {
+				t.Fatalf("r=%d: bucket(%v) = %d, 应为 %d", resolution, bound, got, i+1)
+			}
+			if i > 0 && bound-1 >= r.bounds[i-1] {
+				if got := r.bucket(bound - 1); got != i {
+					t.Fatalf("r=%d: bucket(%v) = %d, 应为 %d", resolution, bound-1, got, i)
+				}
+			}
+		}
+		if got := r.bucket(24 * time.Hour); got != len(r.bounds) {
+			t.Fatalf("r=%d: 超出范围的延迟在桶 %d, 应为最后一个桶 %d", resolution, got, len(r.bounds))
+		}
+	}
+}
+
+func TestLatencyHistogramsCarryLabel(t *testing.T) {
+	s := newFakeServer(t)
+	path := t.TempDir() + "/latency.log"
+	c := newTestClient(t, s, WithLabel("orders"), WithLatencyLog(path, time.Hour))
+
+	if err := c.Put("default", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range c.LatencyHistograms() {
+		if h.Label != "orders" {
+			t.Fatalf("LatencyHistograms: label %q", h.Label)
+		}
+	}
+	c.Close() // 写入最后一个区间
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for _, line := range lines {
+		var h LatencyHistogram
+		if err := json.Unmarshal([]byte(line), &h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Label != "orders" {
+			t.Fatalf("日志行缺少标签: %s", line)
+		}
+	}
+	if len(lines) == 0 || lines[0] == "" {
+		t.Fatal("没有写入延迟日志")
+	}
+}
+
+// TestInfoStaleWhileRequestHangs 没有超时的请求挂起时, Info 在自己的超时内返回缓存的旧结果
+func TestInfoStaleWhileRequestHangs(t *testing.T) {
+	s := newFakeServer(t)
+	started := make(chan struct{})
+	s.hook = func(cmd map[string]json.RawMessage) []byte {
+		if string(cmd["type"]) == `"Get"` {
+			close(started)
+			return fakeHang
+		}
+		return nil
+	}
+	s.put("default", "k", "v")
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := newTestClient(t, s, WithClock(clock), WithMetadataCacheTTL(time.Minute),
+		WithInfoTimeout(200*time.Millisecond), WithStaleInfoFallback())
+
+	if info, err := c.InfoDetailed(); err != nil || info.Stale || info.TotalKeys != 1 {
+		t.Fatalf("首次 Info: %+v %v", info, err)
+	}
+
+	go c.Get("default", "k")
+	<-started
+	clock.Advance(time.Minute)
+
+	start := time.Now()
+	info, err := c.InfoDetailed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Info 等待了 %v", elapsed)
+	}
+	if !info.Stale || info.TotalKeys != 1 || info.Age != time.Minute {
+		t.Fatalf("应返回缓存的旧结果: %+v", info)
+	}
+
+	// 没有启用回退的客户端得到超时错误, 同样不会等到挂起的请求结束
+	c.staleInfoFallback = false
+	if _, err := c.InfoDetailed(ForceRefresh()); !isTimeout(err) {
+		t.Fatalf("ForceRefresh: %v", err)
+	}
+}
+
+// TestPagedOperationsShrinkOversizedPages 默认页大小的响应超过单帧时, 减小页大小后完成
+func TestPagedOperationsShrinkOversizedPages(t *testing.T) {
+	value := strings.Repeat("v", 40)
+
+	s := newFakeServer(t)
+	for i := 0; i < 150; i++ {
+		s.put("default", fmt.Sprintf("key:%04d", i), value)
+	}
+	c := newTestClient(t, s, WithDebugOutput(io.Discard))
+
+	end := "key;"
+	result, err := c.DeleteRange(context.Background(), "default", "key:", &end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Deleted != 150 || s.len() != 0 {
+		t.Fatalf("删除 %d 个, 剩余 %d 个", result.Deleted, s.len())
+	}
+
+	for i := 0; i < 150; i++ {
+		s.put("", fmt.Sprintf("key:%04d", i), value)
+	}
+	copied, err := c.MigrateEmptyCF(context.Background(), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if copied != 150 {
+		t.Fatalf("复制了 %d 个键", copied)
+	}
+}
+
+func TestDisabledCommandIsRejectedLocally(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard))
+
+	c.Controls().DisableCommand("Put")
+	if err := c.Put("default", "k", "v"); !errors.Is(err, ErrAdministrativelyDisabled) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := len(s.received("Put")); n != 0 {
+		t.Fatalf("被禁用的命令发送了 %d 次", n)
+	}
+	if errs := c.RecentErrors(); len(errs) != 1 || errs[0].Class != ErrorClassDisabled {
+		t.Fatalf("错误历史: %+v", errs)
+	}
+
+	c.Controls().EnableCommand("Put")
+	if err := c.Put("default", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestAdminEndpointMergesPostedFields POST 只修改请求中出现的字段
+func TestAdminEndpointMergesPostedFields(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithAdminEndpoint("127.0.0.1:0"))
+	url := "http://" + c.admin.Addr().String() + "/controls"
+
+	post := func(body string) ControlState {
+		t.Helper()
+		resp, err := http.Post(url, "application/json", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("POST %s: %s", body, resp.Status)
+		}
+		var st ControlState
+		if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+			t.Fatal(err)
+		}
+		return st
+	}
+
+	c.Controls().SetGlobalRateLimit(1000)
+	st := post(`{"disabled_commands": {"Scan": {"by": "oncall", "reason": "事故"}}}`)
+	if _, ok := st.DisabledCommands["Scan"]; !ok || st.GlobalRateLimit != 1000 {
+		t.Fatalf("禁用 Scan 后: %+v", st)
+	}
+	_, err := c.ScanItems("default", "", nil, 0)
+	if !errors.Is(err, ErrAdministrativelyDisabled) || !strings.Contains(err.Error(), "oncall") {
+		t.Fatalf("err = %v", err)
+	}
+
+	st = post(`{"max_scan_limit": 5}`)
+	if _, ok := st.DisabledCommands["Scan"]; !ok || st.MaxScanLimit != 5 || st.GlobalRateLimit != 1000 {
+		t.Fatalf("设置上限后: %+v", st)
+	}
+
+	st = post(`{"disabled_commands": {"Scan": null}, "global_rate_limit": 0}`)
+	if len(st.DisabledCommands) != 0 || st.MaxScanLimit != 5 || st.GlobalRateLimit != 0 {
+		t.Fatalf("恢复 Scan 后: %+v", st)
+	}
+	if _, err := c.ScanItems("default", "", nil, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(url, "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("无效的 JSON: %s", resp.Status)
+	}
+}
+
+// TestCloseInterruptsRateLimitWait 等待速率限制的请求在 Close 后以 ErrClosed 返回
+func TestCloseInterruptsRateLimitWait(t *testing.T) {
+	s := newFakeServer(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithClock(clock))
+	c.Controls().SetGlobalRateLimit(1)
+
+	if err := c.Put("default", "a", "v"); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Put("default", "b", "v") }()
+	for clock.BlockedTimers() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	c.Close()
+	select {
+	case err := <-done:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("err = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close 没有中断速率限制的等待")
+	}
+	if n := len(s.received("Put")); n != 1 {
+		t.Fatalf("发送了 %d 个 Put", n)
+	}
+}
+
+// TestWatchThresholdFollowsKeyCount 键数增减越过阈值时回调, 回调 panic 后继续监视
+func TestWatchThresholdFollowsKeyCount(t *testing.T) {
+	s := newFakeServer(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithClock(clock), WithMetadataCacheTTL(0))
+
+	calls := make(chan InfoDelta, 4)
+	panicked := false
+	err := c.WatchThreshold(context.Background(), "total_keys", 3, time.Second, func(d InfoDelta) {
+		calls <- d
+		if !panicked {
+			panicked = true
+			panic("回调出错")
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tick 在采样协程进入等待后推进一个间隔, 等它完成这次采样并再次进入等待
+	tick := func() {
+		for clock.BlockedTimers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Second)
+		for clock.BlockedTimers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	crossing := func(old, cur int) {
+		t.Helper()
+		select {
+		case d := <-calls:
+			ch, _ := d.Change("total_keys")
+			if ch.Old != old || ch.New != cur {
+				t.Fatalf("变化 %v -> %v, 期望 %d -> %d", ch.Old, ch.New, old, cur)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("没有回调 %d -> %d", old, cur)
+		}
+	}
+
+	s.put("default", "k1", "v")
+	tick() // 基准: 1
+	s.put("default", "k2", "v")
+	tick() // 2, 未越过
+	s.put("default", "k3", "v")
+	s.put("default", "k4", "v")
+	tick()
+	crossing(2, 4)
+	if err := c.Delete("default", "k4"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("default", "k3"); err != nil {
+		t.Fatal(err)
+	}
+	tick()
+	crossing(4, 2) // 第一次回调 panic 后仍在监视
+
+	select {
+	case d := <-calls:
+		t.Fatalf("多余的回调: %+v", d)
+	default:
+	}
+}
+
+// TestPollInfoEndsOnClose 客户端关闭后通道以 ErrClosed 结束, 即使 ctx 不会结束
+func TestPollInfoEndsOnClose(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard))
+
+	deltas, err := c.PollInfo(context.Background(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	var last InfoDelta
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case d, ok := <-deltas:
+			if !ok {
+				if !errors.Is(last.Err, ErrClosed) {
+					t.Fatalf("最后的 Err = %v", last.Err)
+				}
+				return
+			}
+			last = d
+		case <-timeout:
+			t.Fatal("Close 后通道没有关闭")
+		}
+	}
+}
+
+// TestDryRunSendsNoMutations 试运行中修改类命令不到达服务器, 读操作照常执行
+func TestDryRunSendsNoMutations(t *testing.T) {
+	s := newFakeServer(t)
+	s.put("default", "user:1", "a")
+	s.put("default", "user:2", "b")
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithDryRun(), WithJournal(t.TempDir()))
+
+	if err := c.Put("default", "user:3", "ccc"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Delete("default", "user:1"); err != nil {
+		t.Fatal(err)
+	}
+	end := "user;"
+	result, err := c.DeleteRange(context.Background(), "default", "user:", &end)
+	if err != nil || result.Deleted != 2 {
+		t.Fatalf("DeleteRange: %+v %v", result, err)
+	}
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := c.Get("default", "user:1"); err != nil || !ok || v != "a" {
+		t.Fatalf("Get = %q, %v, %v", v, ok, err)
+	}
+
+	for _, cmdType := range []string{"Put", "Delete", "Flush"} {
+		if n := len(s.received(cmdType)); n != 0 {
+			t.Errorf("试运行发送了 %d 个 %s", n, cmdType)
+		}
+	}
+	if len(s.received("Scan")) == 0 || len(s.received("Get")) == 0 {
+		t.Error("读操作应当照常发送")
+	}
+	if s.len() != 2 {
+		t.Fatalf("服务器上剩余 %d 个键", s.len())
+	}
+
+	var got []string
+	for _, e := range c.DryRunLog() {
+		got = append(got, e.Command+" "+e.Key)
+	}
+	want := []string{`Put "user:3"`, `Delete "user:1"`, `Delete "user:1"`, `Delete "user:2"`, `Flush `}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("DryRunLog = %q", got)
+	}
+	if c.journal != nil {
+		t.Fatal("试运行不应打开请求日志")
+	}
+}
+
+// TestScanPartialResults 服务器同时返回错误和数据时, 各扫描接口都返回带部分结果的 PartialResultError
+func TestScanPartialResults(t *testing.T) {
+	s := newFakeServer(t)
+	s.setHook(func(cmd map[string]json.RawMessage) []byte {
+		if string(cmd["type"]) != `"Scan"` {
+			return nil
+		}
+		return []byte(`{"Values":[["a","1"],["b","2"],["c","3"]],"Error":"扫描达到内部上限"}`)
+	})
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithDialect(FlatDialect), WithoutSanityCheck())
+
+	partialOf := func(t *testing.T, err error) *PartialResultError {
+		t.Helper()
+		var partial *PartialResultError
+		if !errors.As(err, &partial) {
+			t.Fatalf("err = %v, 期望 PartialResultError", err)
+		}
+		if partial.Op != "Scan" || partial.Message != "扫描达到内部上限" {
+			t.Fatalf("partial = %+v", partial)
+		}
+		return partial
+	}
+
+	t.Run("ScanItems", func(t *testing.T) {
+		items, err := c.ScanItems("default", "", nil, 0)
+		if items != nil {
+			t.Fatalf("出错时不应返回结果: %+v", items)
+		}
+		want := []ScanItem{{"a", "1"}, {"b", "2"}, {"c", "3"}}
+		if got := partialOf(t, err).Items; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Items = %+v", got)
+		}
+	})
+
+	t.Run("ScanPage", func(t *testing.T) {
+		// 多读的探测条目不属于这一页
+		page, err := c.ScanPage("default", "", nil, 2)
+		if page != nil {
+			t.Fatalf("出错时不应返回页: %+v", page)
+		}
+		want := []ScanItem{{"a", "1"}, {"b", "2"}}
+		if got := partialOf(t, err).Items; !reflect.DeepEqual(got, want) {
+			t.Fatalf("Items = %+v", got)
+		}
+	})
+
+	t.Run("Scan", func(t *testing.T) {
+		maps, err := c.Scan("default", "", nil, 0)
+		if maps != nil || len(partialOf(t, err).Items) != 3 {
+			t.Fatalf("Scan = %+v, %v", maps, err)
+		}
+	})
+
+	t.Run("DeleteRange", func(t *testing.T) {
+		end := "z"
+		result, err := c.DeleteRange(context.Background(), "default", "a", &end)
+		partialOf(t, err)
+		if result.Deleted != 0 || result.Cursor != "a" || len(s.received("Delete")) != 0 {
+			t.Fatalf("部分结果不应被删除: %+v", result)
+		}
+	})
+}
+
+// TestLabelsSeparateClients 同一进程中的两个客户端各自带自己的标签
+func TestLabelsSeparateClients(t *testing.T) {
+	s := newFakeServer(t)
+	var primaryOut, replicaOut bytes.Buffer
+	primary := newTestClient(t, s, WithLabel("primary"), WithDebugOutput(&primaryOut))
+	replica := newTestClient(t, s, WithLabel("replica"), WithDebugOutput(&replicaOut))
+
+	if err := primary.Put("default", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := replica.Get("default", "k"); err != nil {
+		t.Fatal(err)
+	}
+	if out := primaryOut.String(); !strings.Contains(out, "[DEBUG primary]") || strings.Contains(out, "replica") {
+		t.Fatalf("primary 的调试输出: %s", out)
+	}
+	if out := replicaOut.String(); !strings.Contains(out, "[DEBUG replica]") || strings.Contains(out, "primary") {
+		t.Fatalf("replica 的调试输出: %s", out)
+	}
+
+	replica.Controls().DisableCommand("Get")
+	replica.Get("default", "k")
+	if errs := replica.RecentErrors(); len(errs) != 1 || errs[0].Label != "replica" {
+		t.Fatalf("replica 的错误历史: %+v", errs)
+	}
+	if errs := primary.RecentErrors(); len(errs) != 0 {
+		t.Fatalf("primary 的错误历史: %+v", errs)
+	}
+
+	labels := map[string]bool{}
+	for _, info := range Clients() {
+		labels[info.Label] = true
+	}
+	if !labels["primary"] || !labels["replica"] {
+		t.Fatalf("Clients 缺少客户端: %v", labels)
+	}
+}
+
+// TestDuplicateLabelRejected 同时存在的客户端标签不能重复, 关闭后可以复用
+func TestDuplicateLabelRejected(t *testing.T) {
+	s := newFakeServer(t)
+	first := newTestClient(t, s, WithLabel("orders"), WithDebugOutput(io.Discard))
+
+	if _, err := NewClient(s.addr(), WithLabel("orders")); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("重复的标签: err = %v", err)
+	}
+	if _, err := NewClient(s.addr(), WithLabel("")); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("空标签: err = %v", err)
+	}
+	n := 0
+	for _, info := range Clients() {
+		if info.Label == "orders" {
+			n++
+		}
+	}
+	if n != 1 {
+		t.Fatalf("Clients 中有 %d 个 orders", n)
+	}
+
+	first.Close()
+	second, err := NewClient(s.addr(), WithLabel("orders"), WithDebugOutput(io.Discard))
+	if err != nil {
+		t.Fatalf("关闭后复用标签: %v", err)
+	}
+	second.Close()
+}
+
+// TestDefaultClientConcurrentInit 并发的第一次使用只连接一次
+func TestDefaultClientConcurrentInit(t *testing.T) {
+	s := newFakeServer(t)
+	t.Setenv("TINYKV_ADDR", s.addr())
+	ResetDefault()
+	t.Cleanup(ResetDefault)
+
+	const n = 20
+	got := make(chan *Client, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c, err := Default()
+			if err != nil {
+				t.Error(err)
+			}
+			got <- c
+		}()
+	}
+	wg.Wait()
+	close(got)
+
+	first := <-got
+	for c := range got {
+		if c != first {
+			t.Fatal("Default 返回了不同的客户端")
+		}
+	}
+	// 每次连接的检查发送一个 Info
+	if conns := len(s.received("Info")); conns != 1 {
+		t.Fatalf("连接了 %d 次", conns)
+	}
+
+	if err := Put("default", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := Get("default", "k"); err != nil || !ok || v != "v" {
+		t.Fatalf("Get = %q, %v, %v", v, ok, err)
+	}
+}
+
+func TestDefaultClientErrors(t *testing.T) {
+	t.Setenv("TINYKV_ADDR", "")
+	ResetDefault()
+	t.Cleanup(ResetDefault)
+
+	if err := Put("default", "k", "v"); !errors.Is(err, ErrNoDefaultClient) {
+		t.Fatalf("未设置默认客户端: err = %v", err)
+	}
+	if _, err := ScanItems("default", "", nil, 0); !errors.Is(err, ErrNoDefaultClient) {
+		t.Fatalf("未设置默认客户端: err = %v", err)
+	}
+
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard))
+	SetDefault(c)
+	if err := Put("default", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if err := Put("default", "k", "v"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Close 之后: err = %v", err)
+	}
+	if _, _, err := Get("default", "k"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Close 之后: err = %v", err)
+	}
+}
+
+// TestWholeCFDeleteNeedsMatchingConfirmation 确认缺失或与列族不符时什么都不删除
+func TestWholeCFDeleteNeedsMatchingConfirmation(t *testing.T) {
+	s := newFakeServer(t)
+	for _, cf := range []string{"default", "orders", "users"} {
+		s.put(cf, "k1", "v")
+		s.put(cf, "k2", "v")
+	}
+	c := newTestClient(t, s, WithDebugOutput(io.Discard))
+
+	rejected := []struct {
+		name string
+		cf   string
+		opts []DeleteRangeOption
+	}{
+		{"无确认", "orders", nil},
+		{"其他列族的确认串", "orders", []DeleteRangeOption{WithConfirmation(ConfirmationToken("users"))}},
+		{"空列族的确认串", "", []DeleteRangeOption{WithConfirmation(ConfirmationToken(""))}},
+		{"确认串优先于 unsafe", "orders", []DeleteRangeOption{WithUnsafeWholeCF(), WithConfirmation("delete-all:order")}},
+	}
+	for _, tc := range rejected {
+		result, err := c.DeleteRange(context.Background(), tc.cf, "", nil, tc.opts...)
+		if !errors.Is(err, ErrConfirmationRequired) || result != nil {
+			t.Errorf("%s: %+v, %v", tc.name, result, err)
+		}
+	}
+	if n := len(s.received("Scan")) + len(s.received("Delete")); n != 0 {
+		t.Fatalf("被拒绝的删除发送了 %d 个命令", n)
+	}
+	if s.len() != 6 {
+		t.Fatalf("剩余 %d 个键", s.len())
+	}
+
+	// 空列族参数映射到 default, 确认串也按 default 计算
+	result, err := c.DeleteRange(context.Background(), "", "", nil, WithConfirmation(ConfirmationToken("default")))
+	if err != nil || result.Deleted != 2 {
+		t.Fatalf("确认后删除: %+v, %v", result, err)
+	}
+	if result, err := c.DeleteRange(context.Background(), "orders", "", nil, WithUnsafeWholeCF()); err != nil || result.Deleted != 2 {
+		t.Fatalf("WithUnsafeWholeCF: %+v, %v", result, err)
+	}
+	if s.len() != 2 {
+		t.Fatalf("只应剩下 users 的 2 个键, 剩余 %d 个", s.len())
+	}
+}
+
+// listenRaw 启动只接受连接的监听器, 每个连接交给 handle; 测试结束时关闭
+func listenRaw(t *testing.T, handle func(conn net.Conn)) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				handle(conn)
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+// TestSanityCheckDiagnosesWrongPeer 对端不是 TinyKV 时 NewClient 返回说明原因的 ErrNotTinyKV
+func TestSanityCheckDiagnosesWrongPeer(t *testing.T) {
+	httpSrv := httptest.NewServer(http.NotFoundHandler())
+	t.Cleanup(httpSrv.Close)
+	tlsSrv := httptest.NewTLSServer(http.NotFoundHandler())
+	t.Cleanup(tlsSrv.Close)
+
+	// 等待 ctx 结束后才关闭连接, 模拟丢弃数据包的防火墙
+	blackHole, stop := context.WithCancel(context.Background())
+	t.Cleanup(stop)
+
+	cases := []struct {
+		name string
+		addr string
+		want string
+	}{
+		{"HTTP", httpSrv.Listener.Addr().String(), "HTTP 服务"},
+		// Go 的 TLS 服务器不回应非握手数据, 直接关闭连接
+		{"TLS", tlsSrv.Listener.Addr().String(), "要求 TLS"},
+		{"TLS 告警", listenRaw(t, func(conn net.Conn) {
+			conn.Read(make([]byte, 512))
+			conn.Write([]byte{0x15, 0x03, 0x03, 0x00, 0x02, 0x02, 0x46}) // 致命告警: protocol_version
+		}), "要求 TLS"},
+		{"关闭连接", listenRaw(t, func(conn net.Conn) {}), "连接被关闭"},
+		{"其他协议", listenRaw(t, func(conn net.Conn) {
+			conn.Read(make([]byte, 512))
+			conn.Write([]byte("-ERR unknown command\r\n"))
+		}), "不是本协议的 JSON"},
+		{"无响应", listenRaw(t, func(conn net.Conn) { <-blackHole.Done() }), "没有响应"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := NewClient(tc.addr)
+			if err == nil {
+				c.Close()
+				t.Fatal("NewClient 应当失败")
+			}
+			if !errors.Is(err, ErrNotTinyKV) || !strings.Contains(err.Error(), tc.want) {
+				t.Fatalf("err = %v, 期望包含 %q", err, tc.want)
+			}
+			if !strings.Contains(err.Error(), tc.addr) {
+				t.Fatalf("错误中缺少地址: %v", err)
+			}
+
+			c, err = NewClient(tc.addr, WithoutSanityCheck(), WithDebugOutput(io.Discard))
+			if err != nil {
+				t.Fatalf("WithoutSanityCheck: %v", err)
+			}
+			c.Close()
+		})
+	}
+}
+
+// TestDrainUnderConcurrentLoad 并发请求期间反复换连接, 请求既不失败也不读到别人的响应
+func TestDrainUnderConcurrentLoad(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard))
+
+	const workers, drains = 8, 20
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				key := fmt.Sprintf("w%d:%d", w, i)
+				if err := c.Put("default", key, key); err != nil {
+					t.Errorf("Put %s: %v", key, err)
+					return
+				}
+				if v, ok, err := c.Get("default", key); err != nil || !ok || v != key {
+					t.Errorf("Get %s = %q, %v, %v", key, v, ok, err)
+					return
+				}
+			}
+		}(w)
+	}
+
+	for i := 0; i < drains; i++ {
+		if err := c.Drain(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+
+	if got := c.Reconnects(); got.Drain != drains || got.Error != 0 {
+		t.Fatalf("Reconnects = %+v", got)
+	}
+	if n := len(s.received("Put")); n == 0 {
+		t.Fatal("没有并发请求")
+	}
+}
+
+func TestProjectJSON(t *testing.T) {
+	doc := `{"id": 12345678901234567890, "name": "a", "user": {"tags": ["x", "y"], "age": 3.50}, "items": [{"id": 1}, {"id": 2}]}`
+	tests := []struct {
+		paths []string
+		want  string
+	}{
+		{[]string{"name", "id"}, `{"name":"a","id":12345678901234567890}`}, // 按 paths 的顺序, 大整数不丢精度
+		{[]string{"user.age", "user.tags.1", "items.1.id"}, `{"user.age":3.50,"user.tags.1":"y","items.1.id":2}`},
+		{[]string{"missing", "user.tags.2", "items.-1", "name.x", "items.first"}, `{"missing":null,"user.tags.2":null,"items.-1":null,"name.x":null,"items.first":null}`},
+		{[]string{"user.tags"}, `{"user.tags":["x","y"]}`},
+		{nil, `{}`},
+	}
+	for _, tt := range tests {
+		got, err := projectJSON([]byte(doc), tt.paths)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%v:\n got  %s\n want %s", tt.paths, got, tt.want)
+		}
+	}
+
+	if _, err := projectJSON([]byte(`{"a":`), []string{"a"}); err == nil {
+		t.Fatal("不完整的 JSON 应当报错")
+	}
+}
+
+func TestScanProject(t *testing.T) {
+	s := newFakeServer(t)
+	s.put("orders", "o:2", `{"id": 2, "total": {"amount": 30}}`)
+	s.put("orders", "o:1", `{"id": 1, "total": {"amount": 10}, "note": "x"}`)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard))
+
+	rows, err := c.ScanProject("orders", "o:", nil, 0, []string{"id", "total.amount", "note"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, row := range rows {
+		got = append(got, string(row))
+	}
+	want := []string{`{"id":1,"total.amount":10,"note":"x"}`, `{"id":2,"total.amount":30,"note":null}`}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("ScanProject = %q", got)
+	}
+
+	s.put("orders", "o:3", "不是 JSON")
+	if _, err := c.ScanProject("orders", "o:", nil, 0, []string{"id"}); err == nil || !strings.Contains(err.Error(), "o:3") {
+		t.Fatalf("无效的值: err = %v", err)
+	}
+}
+
+// TestErrorHistoryKeepsNewest 环形缓冲区只保留最近的记录, 从旧到新返回
+func TestErrorHistoryKeepsNewest(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithErrorHistory(3))
+	c.Controls().DisableCommand("Get")
+
+	for i := 0; i < 5; i++ {
+		c.Get("default", fmt.Sprintf("k%d", i))
+	}
+	var keys []string
+	var seqs []uint64
+	for _, rec := range c.RecentErrors() {
+		keys = append(keys, rec.Key)
+		seqs = append(seqs, rec.Seq)
+	}
+	if !reflect.DeepEqual(keys, []string{`"k2"`, `"k3"`, `"k4"`}) || !reflect.DeepEqual(seqs, []uint64{2, 3, 4}) {
+		t.Fatalf("keys %v seqs %v", keys, seqs)
+	}
+
+	off := newTestClient(t, s, WithDebugOutput(io.Discard), WithErrorHistory(0))
+	off.Controls().DisableCommand("Get")
+	off.Get("default", "k")
+	if errs := off.RecentErrors(); errs != nil {
+		t.Fatalf("禁用后仍有记录: %+v", errs)
+	}
+}
+
+func TestErrorHistoryConcurrentRecording(t *testing.T) {
+	h := newErrorHistory(16)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 500; i++ {
+				h.record(&ErrorRecord{Command: "Get"})
+			}
+		}()
+	}
+	wg.Wait()
+
+	records := h.snapshot()
+	if len(records) != 16 {
+		t.Fatalf("保留了 %d 条", len(records))
+	}
+	for i := 1; i < len(records); i++ {
+		if records[i].Seq <= records[i-1].Seq {
+			t.Fatalf("序号未严格递增: %d, %d", records[i-1].Seq, records[i].Seq)
+		}
+	}
+	if last := records[len(records)-1].Seq; last != 8*500-1 {
+		t.Fatalf("最后的序号 %d", last)
+	}
+}
+
+func TestErrorHistoryNeverStoresValues(t *testing.T) {
+	s := newFakeServer(t)
+	s.setHook(func(cmd map[string]json.RawMessage) []byte {
+		return fakeResponse("Error", "磁盘已满")
+	})
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithoutSanityCheck())
+
+	if err := c.Put("default", "k", "secret-value"); err == nil {
+		t.Fatal("Put 应当失败")
+	}
+	data, err := json.Marshal(c.RecentErrors())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("secret-value")) || !bytes.Contains(data, []byte("磁盘已满")) {
+		t.Fatalf("错误历史: %s", data)
+	}
+}
+
+// TestErrorHistoryRecordsRequestLabelAndAttempts ctx 中的请求标签和重试次数进入错误历史
+func TestErrorHistoryRecordsRequestLabelAndAttempts(t *testing.T) {
+	s := newFakeServer(t)
+	for i := 0; i < 150; i++ {
+		s.put("default", fmt.Sprintf("key:%04d", i), strings.Repeat("v", 40))
+	}
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithAdminEndpoint("127.0.0.1:0"))
+	ctx := WithRequestLabel(context.Background(), "req-42")
+
+	// 整页超过单帧, 缩小页大小重试
+	end := "key;"
+	if _, err := c.DeleteRange(ctx, "default", "key:", &end); err != nil {
+		t.Fatal(err)
+	}
+	var attempts []int
+	for _, rec := range c.RecentErrors() {
+		if rec.Command != "Scan" || rec.RequestLabel != "req-42" {
+			t.Fatalf("记录: %+v", rec)
+		}
+		attempts = append(attempts, rec.Attempt)
+	}
+	if len(attempts) == 0 || attempts[0] != 1 || attempts[len(attempts)-1] < 2 {
+		t.Fatalf("尝试次数: %v", attempts)
+	}
+
+	c.Controls().DisableCommand("Get")
+	c.GetContext(ctx, "default", "key:0001")
+	c.Get("default", "key:0002")
+	errs := c.RecentErrors()
+	if got := errs[len(errs)-2]; got.RequestLabel != "req-42" || got.Attempt != 1 {
+		t.Fatalf("GetContext 的记录: %+v", got)
+	}
+	if got := errs[len(errs)-1]; got.RequestLabel != "" || got.Attempt != 1 {
+		t.Fatalf("Get 的记录: %+v", got)
+	}
+
+	var out bytes.Buffer
+	if err := dumpErrors(&out, c.admin.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	text := out.String()
+	for _, want := range []string{"请求 req-42", "Scan default", "第 2 次", "disabled", fmt.Sprintf("共 %d 条", len(errs))} {
+		if !strings.Contains(text, want) {
+			t.Errorf("输出缺少 %q:\n%s", want, text)
+		}
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := c.PutContext(canceled, "default", "k", "v"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("ctx 已取消: err = %v", err)
+	}
+	if n := len(s.received("Put")); n != 0 {
+		t.Fatalf("ctx 已取消仍发送了 %d 个 Put", n)
+	}
+}
+
+func TestJSONValidator(t *testing.T) {
+	tests := []struct {
+		topLevel string
+		value    string
+		ok       bool
+	}{
+		{"", `{"a":1}`, true},
+		{"", `[1,2]`, true},
+		{"", `"s"`, true},
+		{"", `  42 `, true},
+		{"", `{"a":}`, false},
+		{"", ``, false},
+		{"", `{} {}`, false},
+		{"object", `{"a":[1]}`, true},
+		{"object", ` {}`, true},
+		{"object", `[{}]`, false},
+		{"array", `[]`, true},
+		{"array", `{}`, false},
+		{"string", `"s"`, false}, // 未知的顶层类型要求
+	}
+	for _, tt := range tests {
+		err := JSONValidator(tt.topLevel)([]byte(tt.value))
+		if (err == nil) != tt.ok {
+			t.Errorf("JSONValidator(%q)(%q) = %v", tt.topLevel, tt.value, err)
+		}
+	}
+
+	err := JSONValidator("")([]byte(`{"a": 1,}`))
+	if err == nil || !strings.Contains(err.Error(), "偏移 9") {
+		t.Fatalf("语法错误应包含偏移: %v", err)
+	}
+}
+
+// TestValueValidatorRejectsBeforeSending 未通过校验的值不发送, 其他列族和 WithoutValidation 不受影响
+func TestValueValidatorRejectsBeforeSending(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithValueValidator("docs", JSONValidator("object")))
+
+	err := c.Put("docs", "d:1", `[1, 2]`)
+	if !errors.Is(err, ErrInvalidValue) || !strings.Contains(err.Error(), `"d:1"`) {
+		t.Fatalf("err = %v", err)
+	}
+	if n := len(s.received("Put")); n != 0 {
+		t.Fatalf("无效的值发送了 %d 次", n)
+	}
+
+	if err := c.Put("docs", "d:1", `{"ok": true}`); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put("raw", "r:1", `[1, 2]`); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Put("docs", "d:2", "not json", WithoutValidation()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.received("Put")); n != 3 {
+		t.Fatalf("发送了 %d 个 Put", n)
+	}
+}
+
+// TestDeprecationWarnedOncePerCallSite 每个调用位置只报告一次, 位置指向调用方
+func TestDeprecationWarnedOncePerCallSite(t *testing.T) {
+	s := newFakeServer(t)
+	var mu sync.Mutex
+	var warnings []DeprecationWarning
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithDeprecationWarnings(func(w DeprecationWarning) {
+		mu.Lock()
+		warnings = append(warnings, w)
+		mu.Unlock()
+	}))
+	SetDefault(c)
+	t.Cleanup(ResetDefault)
+
+	for i := 0; i < 3; i++ {
+		c.Scan("default", "", nil, 0) // 同一位置调用三次
+	}
+	c.Scan("default", "", nil, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Info() // 并发调用也只报告一次
+		}()
+	}
+	wg.Wait()
+	Scan("default", "", nil, 0)
+	c.ScanItems("default", "", nil, 0)
+
+	var got []string
+	for _, w := range warnings {
+		if !strings.Contains(w.Caller, "client_go_test.go:") {
+			t.Errorf("调用位置应指向测试文件: %+v", w)
+		}
+		got = append(got, w.API+"->"+w.Replacement)
+	}
+	want := []string{"Client.Scan->Client.ScanItems", "Client.Scan->Client.ScanItems", "Client.Info->Client.InfoDetailed", "Scan->ScanItems"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("warnings = %v", got)
+	}
+	if warnings[0].Caller == warnings[1].Caller {
+		t.Fatalf("两个调用位置相同: %s", warnings[0].Caller)
+	}
+}
+
+// TestInfoCacheSharesFetches 并发调用共用一次请求; ForceRefresh 和 WithoutMetadataCache 绕过缓存
+func TestInfoCacheSharesFetches(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithMetadataCacheTTL(time.Minute))
+
+	gate := make(chan struct{})
+	s.setHook(func(cmd map[string]json.RawMessage) []byte {
+		if string(cmd["type"]) == `"Info"` {
+			<-gate
+		}
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.InfoDetailed(); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for len(s.received("Info")) < 2 { // 连接检查加第一次请求
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+	close(gate)
+	wg.Wait()
+	if n := len(s.received("Info")); n != 2 {
+		t.Fatalf("并发调用发送了 %d 次 Info", n-1)
+	}
+
+	if _, err := c.InfoDetailed(ForceRefresh()); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.received("Info")); n != 3 {
+		t.Fatalf("ForceRefresh 后共 %d 次 Info", n)
+	}
+
+	s2 := newFakeServer(t)
+	uncached := newTestClient(t, s2, WithDebugOutput(io.Discard), WithoutMetadataCache())
+	for i := 0; i < 3; i++ {
+		if _, err := uncached.InfoDetailed(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(s2.received("Info")); n != 1+3 {
+		t.Fatalf("禁用缓存后共 %d 次 Info", n)
+	}
+}
+
+// leakCheck 返回一个函数, 调用时等待协程数回到调用 leakCheck 时的水平
+func leakCheck(t *testing.T) func() {
+	before := runtime.NumGoroutine()
+	return func() {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for runtime.NumGoroutine() > before {
+			if time.Now().After(deadline) {
+				t.Fatalf("协程数从 %d 增加到 %d", before, runtime.NumGoroutine())
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// assertUsable 回调 panic 之后客户端仍能正常读写
+func assertUsable(t *testing.T, c *Client) {
+	t.Helper()
+	if err := c.Put("default", "after-panic", "v"); err != nil {
+		t.Fatalf("panic 之后 Put: %v", err)
+	}
+	if v, ok, err := c.Get("default", "after-panic"); err != nil || !ok || v != "v" {
+		t.Fatalf("panic 之后 Get = %q, %v, %v", v, ok, err)
+	}
+}
+
+// assertCallbackPanic err 是带调用栈的 ErrCallbackPanic
+func assertCallbackPanic(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, ErrCallbackPanic) || !strings.Contains(err.Error(), "回调出错") || !strings.Contains(err.Error(), "goroutine ") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestValidatorPanicIsReturned(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithValueValidator("default", func(v []byte) error {
+		if string(v) == "boom" {
+			panic("回调出错")
+		}
+		return nil
+	}))
+	checkLeaks := leakCheck(t)
+
+	assertCallbackPanic(t, c.Put("default", "k", "boom"))
+	if n := len(s.received("Put")); n != 0 {
+		t.Fatalf("发送了 %d 个 Put", n)
+	}
+	assertUsable(t, c)
+	checkLeaks()
+}
+
+// panicSigner 第一次签名时 panic, 之后使用 HMAC 签名
+type panicSigner struct {
+	panicked atomic.Bool
+}
+
+func (s *panicSigner) Sign(payload []byte) (*Signature, error) {
+	if !s.panicked.Swap(true) {
+		panic("回调出错")
+	}
+	return HMACSigner{KeyID: "k1", Key: []byte("secret")}.Sign(payload)
+}
+
+func TestSignerPanicIsReturned(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithSigner(&panicSigner{}))
+	checkLeaks := leakCheck(t)
+
+	assertCallbackPanic(t, c.Put("default", "k", "v"))
+	if n := len(s.received("Put")); n != 0 {
+		t.Fatalf("发送了 %d 个 Put", n)
+	}
+	assertUsable(t, c)
+	checkLeaks()
+}
+
+func TestDeleteProgressPanicIsReturned(t *testing.T) {
+	s := newFakeServer(t)
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		s.put("default", k, k)
+	}
+	c := newTestClient(t, s, WithDebugOutput(io.Discard))
+	checkLeaks := leakCheck(t)
+
+	end := "z"
+	calls := 0
+	result, err := c.DeleteRange(context.Background(), "default", "a", &end, WithDeletePageSize(2),
+		WithDeleteProgress(func(DeleteRangeProgress) {
+			if calls++; calls == 1 {
+				panic("回调出错")
+			}
+		}))
+	assertCallbackPanic(t, err)
+	if result.Deleted != 2 || result.Cursor != "c" || s.len() != 3 {
+		t.Fatalf("result = %+v, 剩余 %d 个", result, s.len())
+	}
+
+	// 从 Cursor 继续即可完成
+	result, err = c.DeleteRange(context.Background(), "default", result.Cursor, &end)
+	if err != nil || result.Deleted != 3 || s.len() != 0 {
+		t.Fatalf("继续: result = %+v, err = %v, 剩余 %d 个", result, err, s.len())
+	}
+	assertUsable(t, c)
+	checkLeaks()
+}
+
+func TestDeprecationPanicIsReturned(t *testing.T) {
+	s := newFakeServer(t)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithDeprecationWarnings(func(DeprecationWarning) {
+		panic("回调出错")
+	}))
+	checkLeaks := leakCheck(t)
+
+	for i := 0; i < 2; i++ {
+		_, err := c.Scan("default", "", nil, 0)
+		if i == 0 {
+			assertCallbackPanic(t, err)
+		} else if err != nil {
+			t.Fatalf("同一位置第二次调用不再报告: %v", err)
+		}
+	}
+	if _, _, err := c.Info(); !errors.Is(err, ErrCallbackPanic) {
+		t.Fatalf("Info: err = %v", err)
+	}
+	assertUsable(t, c)
+	checkLeaks()
+}
+
+func TestValueSizeWarningPanicKeepsDispatching(t *testing.T) {
+	s := newFakeServer(t)
+	warnings := make(chan ValueSizeWarning, 2)
+	c := newTestClient(t, s, WithDebugOutput(io.Discard), WithValueSizeWarning(1, func(w ValueSizeWarning) {
+		warnings <- w
+		if w.Prefix == `"a:"` {
+			panic("回调出错")
+		}
+	}))
+	checkLeaks := leakCheck(t)
+
+	for _, key := range []string{"a:1", "b:1"} {
+		if err := c.Put("default", key, "large"); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case w := <-warnings:
+			if w.Key != formatKey([]byte(key)) {
+				t.Fatalf("告警 %+v", w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%s 没有回调, 投递协程已退出", key)
+		}
+	}
+	assertUsable(t, c)
+	checkLeaks()
+}
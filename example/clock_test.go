@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// FakeClock 只在 Advance 时前进的时钟
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+// NewFakeClock 创建从 start 开始的 FakeClock
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now 实现 Clock
+func (f *FakeClock) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// NewTimer 实现 Clock
+func (f *FakeClock) NewTimer(d time.Duration) Timer {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	t := &fakeTimer{clock: f, deadline: f.now.Add(d), ch: make(chan time.Time, 1)}
+	if d <= 0 {
+		t.ch <- f.now
+		return t
+	}
+	f.timers = append(f.timers, t)
+	return t
+}
+
+// Sleep 实现 Clock
+func (f *FakeClock) Sleep(ctx context.Context, d time.Duration) error {
+	t := f.NewTimer(d)
+	select {
+	case <-t.C():
+		return nil
+	case <-ctx.Done():
+		t.Stop()
+		return ctx.Err()
+	}
+}
+
+// Advance 推进时间并触发所有到期的定时器
+func (f *FakeClock) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+	pending := f.timers[:0]
+	for _, t := range f.timers {
+		if t.deadline.After(f.now) {
+			pending = append(pending, t)
+			continue
+		}
+		t.ch <- f.now
+	}
+	f.timers = pending
+}
+
+// BlockedTimers 返回尚未触发的定时器数量, 测试可据此等待后台协程进入等待状态
+func (f *FakeClock) BlockedTimers() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.timers)
+}
+
+type fakeTimer struct {
+	clock    *FakeClock
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time { return t.ch }
+
+func (t *fakeTimer) Stop() bool {
+	f := t.clock
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for i, pending := range f.timers {
+		if pending == t {
+			f.timers = append(f.timers[:i], f.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+func TestFakeClockFiresDueTimers(t *testing.T) {
+	clock := NewFakeClock(time.Unix(0, 0))
+	short := clock.NewTimer(time.Second)
+	long := clock.NewTimer(time.Minute)
+	if n := clock.BlockedTimers(); n != 2 {
+		t.Fatalf("BlockedTimers = %d", n)
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case <-short.C():
+	default:
+		t.Fatal("到期的定时器没有触发")
+	}
+	select {
+	case <-long.C():
+		t.Fatal("未到期的定时器被触发")
+	default:
+	}
+	if !long.Stop() || clock.BlockedTimers() != 0 {
+		t.Fatalf("Stop 后 BlockedTimers = %d", clock.BlockedTimers())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := clock.Sleep(ctx, time.Hour); err != context.Canceled {
+		t.Fatalf("Sleep: %v", err)
+	}
+}
+
+// TestMetadataCacheUsesClock 缓存过期由注入的时钟决定, 测试不需要真的等待
+func TestMetadataCacheUsesClock(t *testing.T) {
+	s := newFakeServer(t)
+	clock := NewFakeClock(time.Unix(0, 0))
+	c := newTestClient(t, s, WithClock(clock), WithMetadataCacheTTL(time.Minute))
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.InfoDetailed(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	clock.Advance(time.Minute)
+	if _, err := c.InfoDetailed(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(s.received("Info")); n != 2+1 { // 加上连接检查
+		t.Fatalf("发送了 %d 次 Info", n)
+	}
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// RunKVConformance 对 newKV 创建的实现运行同一组行为检查, 每个子测试使用新的实例
+//
+// 实现了 ConditionalKV 的实例还检查条件写入. 新的实现 (或包装) 在自己的测试中
+// 调用该函数, 确保边界行为与 Client 一致.
+func RunKVConformance(t *testing.T, newKV func(t *testing.T) KV) {
+	// mustPut 写入 pairs, 按 key, value 交替排列
+	mustPut := func(t *testing.T, kv KV, cf string, pairs ...string) {
+		t.Helper()
+		for i := 0; i < len(pairs); i += 2 {
+			if err := kv.Put(cf, pairs[i], pairs[i+1]); err != nil {
+				t.Fatalf("Put %q: %v", pairs[i], err)
+			}
+		}
+	}
+	// scanKeys 返回扫描到的键, 没有结果时为空切片
+	scanKeys := func(t *testing.T, kv KV, cf, start string, end *string, limit int) []string {
+		t.Helper()
+		items, err := kv.ScanItems(cf, start, end, limit)
+		if err != nil {
+			t.Fatalf("ScanItems(%q, %q, %v, %d): %v", cf, start, end, limit, err)
+		}
+		keys := []string{}
+		for _, item := range items {
+			keys = append(keys, item.Key)
+		}
+		return keys
+	}
+	expectKeys := func(t *testing.T, got []string, want ...string) {
+		t.Helper()
+		if want == nil {
+			want = []string{}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("键 %q, 期望 %q", got, want)
+		}
+	}
+	expectValue := func(t *testing.T, kv KV, cf, key, want string) {
+		t.Helper()
+		v, ok, err := kv.Get(cf, key)
+		if err != nil || !ok || v != want {
+			t.Fatalf("Get(%q, %q) = %q, %v, %v; 期望 %q", cf, key, v, ok, err, want)
+		}
+	}
+	end := func(s string) *string { return &s }
+
+	t.Run("缺失的键", func(t *testing.T) {
+		kv := newKV(t)
+		if v, ok, err := kv.Get("default", "missing"); err != nil || ok || v != "" {
+			t.Fatalf("Get = %q, %v, %v", v, ok, err)
+		}
+		if err := kv.Delete("default", "missing"); err != nil {
+			t.Fatalf("删除不存在的键: %v", err)
+		}
+		expectKeys(t, scanKeys(t, kv, "never-written", "", nil, 0))
+	})
+
+	t.Run("覆盖和删除", func(t *testing.T) {
+		kv := newKV(t)
+		mustPut(t, kv, "default", "k", "v1", "k", "v2")
+		expectValue(t, kv, "default", "k", "v2")
+		if err := kv.Delete("default", "k"); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, _ := kv.Get("default", "k"); ok {
+			t.Fatal("删除后仍存在")
+		}
+		expectKeys(t, scanKeys(t, kv, "default", "", nil, 0))
+	})
+
+	t.Run("空值与不存在不同", func(t *testing.T) {
+		kv := newKV(t)
+		mustPut(t, kv, "default", "empty", "")
+		expectValue(t, kv, "default", "empty", "")
+		items, err := kv.ScanItems("default", "", nil, 0)
+		if err != nil || len(items) != 1 || items[0] != (ScanItem{"empty", ""}) {
+			t.Fatalf("ScanItems = %q, %v", items, err)
+		}
+	})
+
+	t.Run("二进制键和值按字节序", func(t *testing.T) {
+		kv := newKV(t)
+		mustPut(t, kv, "default", "\xff\xfe", "\x00", "a\x00b", "\xff", "\x00", "zero", "a", "\x80\x81")
+		expectKeys(t, scanKeys(t, kv, "default", "", nil, 0), "\x00", "a", "a\x00b", "\xff\xfe")
+		expectValue(t, kv, "default", "\xff\xfe", "\x00")
+		expectValue(t, kv, "default", "a", "\x80\x81")
+	})
+
+	t.Run("Unicode", func(t *testing.T) {
+		kv := newKV(t)
+		mustPut(t, kv, "列族", "键:é", "值 🚀", "键:a", "ascii")
+		expectKeys(t, scanKeys(t, kv, "列族", "键:", nil, 0), "键:a", "键:é")
+		expectValue(t, kv, "列族", "键:é", "值 🚀")
+	})
+
+	t.Run("空列族为默认列族", func(t *testing.T) {
+		kv := newKV(t)
+		mustPut(t, kv, "", "k", "v")
+		expectValue(t, kv, "default", "k", "v")
+		expectKeys(t, scanKeys(t, kv, "", "", nil, 0), "k")
+	})
+
+	t.Run("列族隔离", func(t *testing.T) {
+		kv := newKV(t)
+		mustPut(t, kv, "cf1", "k", "1", "only1", "x")
+		mustPut(t, kv, "cf2", "k", "2")
+		expectValue(t, kv, "cf1", "k", "1")
+		expectValue(t, kv, "cf2", "k", "2")
+		expectKeys(t, scanKeys(t, kv, "cf2", "", nil, 0), "k")
+		if _, ok, _ := kv.Get("cf2", "only1"); ok {
+			t.Fatal("cf2 中读到了 cf1 的键")
+		}
+	})
+
+	t.Run("扫描范围", func(t *testing.T) {
+		kv := newKV(t)
+		mustPut(t, kv, "default", "a", "1", "b", "2", "c", "3", "d", "4")
+		expectKeys(t, scanKeys(t, kv, "default", "b", end("d"), 0), "b", "c") // 起点包含, 终点不包含
+		expectKeys(t, scanKeys(t, kv, "default", "", nil, 0), "a", "b", "c", "d")
+		expectKeys(t, scanKeys(t, kv, "default", "bb", nil, 0), "c", "d")
+		expectKeys(t, scanKeys(t, kv, "default", "", end("a"), 0))
+		expectKeys(t, scanKeys(t, kv, "default", "c", end("c"), 0))
+		expectKeys(t, scanKeys(t, kv, "default", "d", end("b"), 0)) // 终点在起点之前
+		expectKeys(t, scanKeys(t, kv, "default", "e", nil, 0))
+	})
+
+	t.Run("扫描上限", func(t *testing.T) {
+		kv := newKV(t)
+		mustPut(t, kv, "default", "a", "1", "b", "2", "c", "3")
+		expectKeys(t, scanKeys(t, kv, "default", "", nil, 2), "a", "b")
+		expectKeys(t, scanKeys(t, kv, "default", "b", nil, 1), "b")
+		expectKeys(t, scanKeys(t, kv, "default", "", nil, 10), "a", "b", "c")
+		if _, err := kv.ScanItems("default", "", nil, -1); !errors.Is(err, ErrInvalidArgument) {
+			t.Fatalf("负数 limit: err = %v", err)
+		}
+	})
+
+	t.Run("前缀边界", func(t *testing.T) {
+		kv := newKV(t)
+		mustPut(t, kv, "default", "p", "", "p\x00", "", "p:1", "", "p:2", "", "p;", "", "q", "")
+		expectKeys(t, scanKeys(t, kv, "default", "p:", end("p;"), 0), "p:1", "p:2")
+		expectKeys(t, scanKeys(t, kv, "default", "p", end("q"), 0), "p", "p\x00", "p:1", "p:2", "p;")
+
+		mustPut(t, kv, "bin", "a\xff", "", "a\xff\x00", "", "b", "")
+		expectKeys(t, scanKeys(t, kv, "bin", "a\xff", end("b"), 0), "a\xff", "a\xff\x00")
+	})
+
+	t.Run("并发写入", func(t *testing.T) {
+		kv := newKV(t)
+		const writers, perWriter = 8, 10
+		var wg sync.WaitGroup
+		errs := make(chan error, writers*perWriter)
+		for w := 0; w < writers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < perWriter; i++ {
+					key := fmt.Sprintf("w%d:%02d", w, i)
+					if err := kv.Put("default", key, key); err != nil {
+						errs <- err
+						return
+					}
+					if v, ok, err := kv.Get("default", key); err != nil || !ok || v != key {
+						errs <- fmt.Errorf("写入后读取 %s = %q, %v, %v", key, v, ok, err)
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+		if keys := scanKeys(t, kv, "default", "", nil, 0); len(keys) != writers*perWriter {
+			t.Fatalf("扫描到 %d 个键", len(keys))
+		}
+	})
+
+	t.Run("条件写入", func(t *testing.T) {
+		kv, ok := newKV(t).(ConditionalKV)
+		if !ok {
+			t.Skip("未实现 ConditionalKV")
+		}
+
+		if ok, err := kv.PutIfAbsent("default", "k", "v1"); !ok || err != nil {
+			t.Fatalf("PutIfAbsent = %v, %v", ok, err)
+		}
+		if ok, _ := kv.PutIfAbsent("default", "k", "v2"); ok {
+			t.Fatal("键已存在时 PutIfAbsent 成功")
+		}
+		if ok, _ := kv.CompareAndSwap("default", "k", "v2", "v3"); ok {
+			t.Fatal("旧值不符时 CompareAndSwap 成功")
+		}
+		if ok, _ := kv.CompareAndSwap("default", "missing", "", "v"); ok {
+			t.Fatal("不存在的键 CompareAndSwap 成功")
+		}
+		if ok, _ := kv.CompareAndSwap("default", "k", "v1", ""); !ok {
+			t.Fatal("CompareAndSwap 失败")
+		}
+		expectValue(t, kv, "default", "k", "")
+		if ok, _ := kv.DeleteIf("default", "k", "v1"); ok {
+			t.Fatal("值不符时 DeleteIf 成功")
+		}
+		if ok, _ := kv.DeleteIf("default", "k", ""); !ok {
+			t.Fatal("DeleteIf 失败")
+		}
+		expectKeys(t, scanKeys(t, kv, "default", "", nil, 0))
+
+		// 并发的 PutIfAbsent 只有一个成功
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		winners := 0
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if ok, err := kv.PutIfAbsent("default", "lock", fmt.Sprint(i)); ok && err == nil {
+					mu.Lock()
+					winners++
+					mu.Unlock()
+				}
+			}(i)
+		}
+		wg.Wait()
+		if winners != 1 {
+			t.Fatalf("%d 个 PutIfAbsent 成功", winners)
+		}
+	})
+}
+
+func TestClientConformance(t *testing.T) {
+	RunKVConformance(t, func(t *testing.T) KV {
+		return newTestClient(t, newFakeServer(t), WithDebugOutput(io.Discard))
+	})
+}
+
+func TestEmbeddedConformance(t *testing.T) {
+	RunKVConformance(t, func(t *testing.T) KV {
+		return openTestEmbedded(t)
+	})
+}
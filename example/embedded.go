@@ -0,0 +1,441 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// embeddedSnapshotFile 快照在目录中的文件名, 每行一个键值对
+const embeddedSnapshotFile = "snapshot.jsonl"
+
+// ConditionalKV 带条件写入的 KV
+//
+// 服务器没有条件命令, 因此 Client 不实现该接口; 目前只有 Embedded 实现.
+type ConditionalKV interface {
+	KV
+	// PutIfAbsent 键不存在时写入, 返回是否写入
+	PutIfAbsent(cf, key, value string) (bool, error)
+	// CompareAndSwap 键存在且值为 old 时改为 new, 返回是否修改
+	CompareAndSwap(cf, key, old, new string) (bool, error)
+	// DeleteIf 键存在且值为 expected 时删除, 返回是否删除
+	DeleteIf(cf, key, expected string) (bool, error)
+}
+
+var _ ConditionalKV = (*Embedded)(nil)
+
+// Embedded 进程内的键值存储, 实现 KV, 用于不需要服务器的测试和小工具
+//
+// 各列族的键按字节序保存, 扫描的起点包含, 终点不包含, 与服务器一致. 设置了快照目录时,
+// 打开时从快照加载, Flush 和 Close 写入快照; WithSnapshotInterval 额外定期写入.
+type Embedded struct {
+	dir      string
+	interval time.Duration
+	clock    Clock
+
+	mu     sync.RWMutex
+	cfs    map[string]*embeddedCF
+	dirty  bool // 上次快照后有修改
+	closed bool
+
+	snapshotMu sync.Mutex // 保证快照文件按顺序写入
+	done       chan struct{}
+	finished   chan struct{}
+}
+
+// embeddedCF 一个列族的数据, keys 按字节序排列
+type embeddedCF struct {
+	keys   []string
+	values map[string]string
+}
+
+// EmbeddedOption OpenEmbedded 的选项
+type EmbeddedOption func(*Embedded)
+
+// WithSnapshotDir 在 dir 中保存快照, 打开时加载已有的快照
+func WithSnapshotDir(dir string) EmbeddedOption {
+	return func(e *Embedded) {
+		e.dir = dir
+	}
+}
+
+// WithSnapshotInterval 有修改时每隔 interval 写入一次快照, 需要同时设置 WithSnapshotDir
+func WithSnapshotInterval(interval time.Duration) EmbeddedOption {
+	return func(e *Embedded) {
+		e.interval = interval
+	}
+}
+
+// WithEmbeddedClock 替换定期快照使用的时钟, 用于测试
+func WithEmbeddedClock(clock Clock) EmbeddedOption {
+	return func(e *Embedded) {
+		e.clock = clock
+	}
+}
+
+// OpenEmbedded 创建进程内存储
+func OpenEmbedded(opts ...EmbeddedOption) (*Embedded, error) {
+	e := &Embedded{
+		clock: realClock{},
+		cfs:   map[string]*embeddedCF{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	if e.interval < 0 {
+		return nil, fmt.Errorf("%w: 快照间隔不能为负数: %v", ErrInvalidArgument, e.interval)
+	}
+	if e.interval > 0 && e.dir == "" {
+		return nil, fmt.Errorf("%w: WithSnapshotInterval 需要 WithSnapshotDir", ErrInvalidArgument)
+	}
+
+	if e.dir != "" {
+		if err := os.MkdirAll(e.dir, 0o755); err != nil {
+			return nil, fmt.Errorf("创建快照目录失败: %w", err)
+		}
+		if err := e.load(); err != nil {
+			return nil, err
+		}
+	}
+
+	if e.interval > 0 {
+		e.done = make(chan struct{})
+		e.finished = make(chan struct{})
+		go e.snapshotLoop()
+	}
+	return e, nil
+}
+
+// embeddedRecord 快照中的一行; 键和值按字节保存, 非 UTF-8 的数据不会被改写
+type embeddedRecord struct {
+	CF    string `json:"cf"`
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// load 读取快照, 文件不存在时为空
+func (e *Embedded) load() error {
+	path := filepath.Join(e.dir, embeddedSnapshotFile)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 64<<20)
+	for line := 1; scanner.Scan(); line++ {
+		var rec embeddedRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return fmt.Errorf("%s 第 %d 行: %w", path, line, err)
+		}
+		e.cf(rec.CF, true).put(string(rec.Key), string(rec.Value))
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取 %s 失败: %w", path, err)
+	}
+	return nil
+}
+
+func (e *Embedded) snapshotLoop() {
+	defer close(e.finished)
+
+	for {
+		timer := e.clock.NewTimer(e.interval)
+		select {
+		case <-timer.C():
+			if err := e.snapshot(false); err != nil {
+				fmt.Fprintf(os.Stderr, "写入快照失败: %v\n", err)
+			}
+		case <-e.done:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// snapshot 将全部数据写入快照; force 为 false 时没有修改则跳过
+//
+// 先写临时文件再改名, 写入中途崩溃时旧快照仍然完整.
+func (e *Embedded) snapshot(force bool) error {
+	e.snapshotMu.Lock()
+	defer e.snapshotMu.Unlock()
+
+	e.mu.Lock()
+	if !force && !e.dirty {
+		e.mu.Unlock()
+		return nil
+	}
+	var records []embeddedRecord
+	for _, name := range e.sortedCFs() {
+		cf := e.cfs[name]
+		for _, k := range cf.keys {
+			records = append(records, embeddedRecord{CF: name, Key: []byte(k), Value: []byte(cf.values[k])})
+		}
+	}
+	e.dirty = false
+	e.mu.Unlock()
+
+	path := filepath.Join(e.dir, embeddedSnapshotFile)
+	tmp, err := os.CreateTemp(e.dir, embeddedSnapshotFile+".*")
+	if err != nil {
+		return e.snapshotFailed(err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for i := range records {
+		if err := enc.Encode(&records[i]); err != nil {
+			tmp.Close()
+			return e.snapshotFailed(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return e.snapshotFailed(err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return e.snapshotFailed(err)
+	}
+	if err := tmp.Close(); err != nil {
+		return e.snapshotFailed(err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return e.snapshotFailed(err)
+	}
+	return nil
+}
+
+// snapshotFailed 写入失败时恢复修改标记, 下一次定期快照重试
+func (e *Embedded) snapshotFailed(err error) error {
+	e.mu.Lock()
+	e.dirty = true
+	e.mu.Unlock()
+	return fmt.Errorf("写入快照失败: %w", err)
+}
+
+// sortedCFs 返回列族名, 按字节序排列; 调用方持有 e.mu
+func (e *Embedded) sortedCFs() []string {
+	names := make([]string, 0, len(e.cfs))
+	for name := range e.cfs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// cf 返回列族, create 为 false 且列族不存在时返回 nil; 调用方持有 e.mu
+func (e *Embedded) cf(name string, create bool) *embeddedCF {
+	cf, ok := e.cfs[name]
+	if !ok && create {
+		cf = &embeddedCF{values: map[string]string{}}
+		e.cfs[name] = cf
+	}
+	return cf
+}
+
+func (cf *embeddedCF) put(key, value string) {
+	if _, ok := cf.values[key]; !ok {
+		i := sort.SearchStrings(cf.keys, key)
+		cf.keys = append(cf.keys, "")
+		copy(cf.keys[i+1:], cf.keys[i:])
+		cf.keys[i] = key
+	}
+	cf.values[key] = value
+}
+
+func (cf *embeddedCF) delete(key string) {
+	if _, ok := cf.values[key]; !ok {
+		return
+	}
+	i := sort.SearchStrings(cf.keys, key)
+	cf.keys = append(cf.keys[:i], cf.keys[i+1:]...)
+	delete(cf.values, key)
+}
+
+// resolveCF 与 Client 相同, 空列族映射到默认列族
+func (e *Embedded) resolveCF(cf string) string {
+	if cf == "" {
+		return "default"
+	}
+	return cf
+}
+
+// Put 存储键值对; PutOption 只影响客户端的校验, 在此忽略
+func (e *Embedded) Put(cf, key, value string, opts ...PutOption) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return ErrClosed
+	}
+	e.cf(e.resolveCF(cf), true).put(key, value)
+	e.dirty = true
+	return nil
+}
+
+// Get 获取值
+func (e *Embedded) Get(cf, key string) (string, bool, error) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return "", false, ErrClosed
+	}
+	c := e.cf(e.resolveCF(cf), false)
+	if c == nil {
+		return "", false, nil
+	}
+	v, ok := c.values[key]
+	return v, ok, nil
+}
+
+// Delete 删除键, 键不存在时不报错
+func (e *Embedded) Delete(cf, key string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return ErrClosed
+	}
+	if c := e.cf(e.resolveCF(cf), false); c != nil {
+		c.delete(key)
+		e.dirty = true
+	}
+	return nil
+}
+
+// ScanItems 扫描 [startKey, endKey), endKey 为 nil 表示到列族末尾; limit 为 0 表示不限制,
+// 负数返回 ErrInvalidArgument
+func (e *Embedded) ScanItems(cf, startKey string, endKey *string, limit int) ([]ScanItem, error) {
+	if limit < 0 {
+		return nil, fmt.Errorf("%w: Scan limit 不能为负数: %d", ErrInvalidArgument, limit)
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return nil, ErrClosed
+	}
+	c := e.cf(e.resolveCF(cf), false)
+	if c == nil {
+		return nil, nil
+	}
+
+	var items []ScanItem
+	for i := sort.SearchStrings(c.keys, startKey); i < len(c.keys); i++ {
+		k := c.keys[i]
+		if endKey != nil && k >= *endKey {
+			break
+		}
+		items = append(items, ScanItem{Key: k, Value: c.values[k]})
+		if limit > 0 && len(items) >= limit {
+			break
+		}
+	}
+	return items, nil
+}
+
+// PutIfAbsent 键不存在时写入, 返回是否写入
+func (e *Embedded) PutIfAbsent(cf, key, value string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return false, ErrClosed
+	}
+	c := e.cf(e.resolveCF(cf), true)
+	if _, ok := c.values[key]; ok {
+		return false, nil
+	}
+	c.put(key, value)
+	e.dirty = true
+	return true, nil
+}
+
+// CompareAndSwap 键存在且值为 old 时改为 new, 返回是否修改
+func (e *Embedded) CompareAndSwap(cf, key, old, new string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return false, ErrClosed
+	}
+	c := e.cf(e.resolveCF(cf), false)
+	if c == nil {
+		return false, nil
+	}
+	if v, ok := c.values[key]; !ok || v != old {
+		return false, nil
+	}
+	c.values[key] = new
+	e.dirty = true
+	return true, nil
+}
+
+// DeleteIf 键存在且值为 expected 时删除, 返回是否删除
+func (e *Embedded) DeleteIf(cf, key, expected string) (bool, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.closed {
+		return false, ErrClosed
+	}
+	c := e.cf(e.resolveCF(cf), false)
+	if c == nil {
+		return false, nil
+	}
+	if v, ok := c.values[key]; !ok || v != expected {
+		return false, nil
+	}
+	c.delete(key)
+	e.dirty = true
+	return true, nil
+}
+
+// Flush 写入快照; 未设置快照目录时什么也不做
+func (e *Embedded) Flush() error {
+	e.mu.RLock()
+	closed := e.closed
+	e.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	if e.dir == "" {
+		return nil
+	}
+	return e.snapshot(true)
+}
+
+// Close 停止定期快照并写入最后一次快照; 之后的操作返回 ErrClosed
+func (e *Embedded) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.mu.Unlock()
+
+	if e.done != nil {
+		close(e.done)
+		<-e.finished
+	}
+	if e.dir == "" {
+		return nil
+	}
+	return e.snapshot(false)
+}
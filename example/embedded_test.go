@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func openTestEmbedded(t *testing.T, opts ...EmbeddedOption) *Embedded {
+	t.Helper()
+	e, err := OpenEmbedded(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.Close() })
+	return e
+}
+
+// TestEmbeddedSnapshotRoundTrip Flush 写入的快照在重新打开时加载, 二进制键值原样保留
+func TestEmbeddedSnapshotRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	e := openTestEmbedded(t, WithSnapshotDir(dir))
+
+	data := map[[2]string]string{
+		{"default", "b"}:         "2",
+		{"default", "a"}:         "1",
+		{"default", "\x00\xff"}:  "\xfe\x00",
+		{"users", "用户:1"}:        "",
+		{"users", "gone-before"}: "x",
+	}
+	for k, v := range data {
+		if err := e.Put(k[0], k[1], v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := e.Delete("users", "gone-before"); err != nil {
+		t.Fatal(err)
+	}
+	if err := e.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened := openTestEmbedded(t, WithSnapshotDir(dir))
+	items, err := reopened.ScanItems("default", "", nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ScanItem{{"\x00\xff", "\xfe\x00"}, {"a", "1"}, {"b", "2"}}
+	if !reflect.DeepEqual(items, want) {
+		t.Fatalf("default: %q", items)
+	}
+	if v, ok, err := reopened.Get("users", "用户:1"); err != nil || !ok || v != "" {
+		t.Fatalf("users: %q, %v, %v", v, ok, err)
+	}
+	if _, ok, _ := reopened.Get("users", "gone-before"); ok {
+		t.Fatal("删除的键在快照中")
+	}
+}
+
+// TestEmbeddedPeriodicSnapshot 有修改时按间隔写入快照, Close 写入最后的修改
+func TestEmbeddedPeriodicSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, embeddedSnapshotFile)
+	clock := NewFakeClock(time.Unix(0, 0))
+	e, err := OpenEmbedded(WithSnapshotDir(dir), WithSnapshotInterval(time.Minute), WithEmbeddedClock(clock))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// tick 推进一个间隔并等待快照协程再次进入等待
+	tick := func() {
+		for clock.BlockedTimers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		clock.Advance(time.Minute)
+		for clock.BlockedTimers() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	tick()
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("没有修改时写入了快照: %v", err)
+	}
+
+	e.Put("default", "k1", "v")
+	tick()
+	if b, err := os.ReadFile(path); err != nil || len(b) == 0 {
+		t.Fatalf("快照: %q, %v", b, err)
+	}
+
+	e.Put("default", "k2", "v")
+	if err := e.Close(); err != nil {
+		t.Fatal(err)
+	}
+	reopened := openTestEmbedded(t, WithSnapshotDir(dir))
+	if items, _ := reopened.ScanItems("default", "", nil, 0); len(items) != 2 {
+		t.Fatalf("Close 后重新打开: %q", items)
+	}
+}
+
+func TestEmbeddedConditionalOps(t *testing.T) {
+	e := openTestEmbedded(t)
+
+	if ok, err := e.CompareAndSwap("default", "k", "", "v"); ok || err != nil {
+		t.Fatalf("不存在的键 CAS 成功: %v", err)
+	}
+	if ok, _ := e.PutIfAbsent("default", "k", "v1"); !ok {
+		t.Fatal("PutIfAbsent 失败")
+	}
+	if ok, _ := e.PutIfAbsent("default", "k", "v2"); ok {
+		t.Fatal("键已存在时 PutIfAbsent 成功")
+	}
+	if ok, _ := e.CompareAndSwap("default", "k", "v2", "v3"); ok {
+		t.Fatal("旧值不符时 CAS 成功")
+	}
+	if ok, _ := e.CompareAndSwap("default", "k", "v1", "v3"); !ok {
+		t.Fatal("CAS 失败")
+	}
+	if ok, _ := e.DeleteIf("default", "k", "v1"); ok {
+		t.Fatal("值不符时 DeleteIf 成功")
+	}
+	if ok, _ := e.DeleteIf("default", "k", "v3"); !ok {
+		t.Fatal("DeleteIf 失败")
+	}
+	if _, ok, _ := e.Get("default", "k"); ok {
+		t.Fatal("DeleteIf 后键仍存在")
+	}
+}
+
+func TestEmbeddedClosedAndInvalidOptions(t *testing.T) {
+	e := openTestEmbedded(t)
+	e.Close()
+	if err := e.Put("default", "k", "v"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := e.ScanItems("default", "", nil, 0); !errors.Is(err, ErrClosed) {
+		t.Fatalf("ScanItems: %v", err)
+	}
+	if _, err := e.PutIfAbsent("default", "k", "v"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("PutIfAbsent: %v", err)
+	}
+
+	if _, err := OpenEmbedded(WithSnapshotInterval(time.Second)); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("没有目录的定期快照: %v", err)
+	}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, embeddedSnapshotFile), []byte("{\"cf\":\n"), 0o644)
+	if _, err := OpenEmbedded(WithSnapshotDir(dir)); err == nil {
+		t.Fatal("损坏的快照没有报错")
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// runErrorsCommand 执行 errors 子命令: 从客户端的管理接口读取错误历史并打印
+func runErrorsCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("%w: 用法: errors <管理接口地址>", ErrInvalidArgument)
+	}
+	return dumpErrors(os.Stdout, args[0])
+}
+
+// dumpErrors 读取 adminAddr 上 /errors 的内容, 每条失败操作打印一行, 从旧到新
+func dumpErrors(w io.Writer, adminAddr string) error {
+	url := adminAddr
+	if !strings.Contains(url, "://") {
+		url = "http://" + url
+	}
+	client := http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(strings.TrimSuffix(url, "/") + "/errors")
+	if err != nil {
+		return fmt.Errorf("读取错误历史失败: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("读取错误历史失败: %s (客户端是否启用了错误历史?)", resp.Status)
+	}
+
+	var records []ErrorRecord
+	if err := json.NewDecoder(resp.Body).Decode(&records); err != nil {
+		return fmt.Errorf("解析错误历史失败: %w", err)
+	}
+
+	for _, rec := range records {
+		fmt.Fprintf(w, "#%d %s", rec.Seq, rec.Time.Format(time.RFC3339Nano))
+		if rec.Label != "" {
+			fmt.Fprintf(w, " [%s]", rec.Label)
+		}
+		if rec.RequestLabel != "" {
+			fmt.Fprintf(w, " 请求 %s", rec.RequestLabel)
+		}
+		fmt.Fprintf(w, " %s", rec.Command)
+		if rec.CF != "" {
+			fmt.Fprintf(w, " %s", rec.CF)
+		}
+		if rec.Key != "" {
+			fmt.Fprintf(w, " %s", rec.Key)
+		}
+		fmt.Fprintf(w, " %s 第 %d 次 连接 %d 耗时 %v: %s\n", rec.Class, rec.Attempt, rec.ConnID, rec.Latency, rec.Error)
+	}
+	fmt.Fprintf(w, "共 %d 条\n", len(records))
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+// batch 示例: 逐个写入一批记录后校验, 再用 DeleteRange 按范围清理
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+func batchExample(ctx context.Context, c *Client) error {
+	users := map[string]string{
+		"user:1": "Alice",
+		"user:2": "Bob",
+		"user:3": "Charlie",
+	}
+
+	keys := make([]string, 0, len(users))
+	for key := range users {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := c.Put("default", key, users[key]); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("✓ 批量写入 %d 条记录\n", len(users))
+
+	for _, key := range keys {
+		if err := expectValue(c, "default", key, users[key]); err != nil {
+			return err
+		}
+		fmt.Printf("  %s = %s\n", key, users[key])
+	}
+
+	end := "user;" // ':' 的下一个字符, 范围覆盖所有 "user:" 开头的键
+	result, err := c.DeleteRange(ctx, "default", "user:", &end)
+	if err != nil {
+		return err
+	}
+	if result.Deleted < len(users) {
+		return fmt.Errorf("DeleteRange 删除了 %d 个键, 期望至少 %d 个", result.Deleted, len(users))
+	}
+	fmt.Printf("✓ DeleteRange: 删除 %d 个键\n", result.Deleted)
+
+	return nil
+}
@@ -0,0 +1,29 @@
+package main
+
+// binary 示例: 键和值可以是任意字节, 包括 NUL 和非法 UTF-8
+
+import (
+	"context"
+	"fmt"
+)
+
+func binaryExample(ctx context.Context, c *Client) error {
+	key := "bin:\x00\x01"
+	value := string([]byte{0x00, 0xff, 0xfe, '\n', 0x80})
+
+	if err := c.Put("default", key, value); err != nil {
+		return err
+	}
+	fmt.Printf("✓ Put: %s = %s\n", formatKey([]byte(key)), formatKey([]byte(value)))
+
+	if err := expectValue(c, "default", key, value); err != nil {
+		return err
+	}
+	fmt.Println("✓ Get: 读回的字节与写入的完全一致")
+
+	// 值越大编码后越长, 写入前可以估算是否超过单帧上限
+	fmt.Printf("✓ 1000 字节的值编码后约 %d 字节 (上限 %d)\n",
+		c.EstimatePutSize("default", key, string(make([]byte, 1000))), maxFrameSize)
+
+	return c.Delete("default", key)
+}
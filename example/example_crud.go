@@ -0,0 +1,44 @@
+package main
+
+// crud 示例: 基本的 Put/Get/Delete, 包括非 ASCII 的键和值
+
+import (
+	"context"
+	"fmt"
+)
+
+func crudExample(ctx context.Context, c *Client) error {
+	if err := c.Put("default", "name", "Alice"); err != nil {
+		return err
+	}
+	fmt.Println("✓ Put: cf=default, key=name, value=Alice")
+	if err := expectValue(c, "default", "name", "Alice"); err != nil {
+		return err
+	}
+	fmt.Println("✓ Get: cf=default, key=name -> Alice")
+
+	if err := c.Put("default", "城市", "北京"); err != nil {
+		return err
+	}
+	if err := expectValue(c, "default", "城市", "北京"); err != nil {
+		return err
+	}
+	fmt.Println("✓ Put/Get: 城市 -> 北京")
+
+	if err := c.Put("default", "temp", "temporary value"); err != nil {
+		return err
+	}
+	if err := c.Delete("default", "temp"); err != nil {
+		return err
+	}
+	_, found, err := c.Get("default", "temp")
+	if err != nil {
+		return err
+	}
+	if found {
+		return fmt.Errorf("键 temp 删除后仍然存在")
+	}
+	fmt.Println("✓ Delete: temp 已删除")
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package main
+
+// errors 示例: 用 errors.Is 区分客户端返回的错误, 而不是比较错误文本
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+func errorsExample(ctx context.Context, c *Client) error {
+	// 参数错误在发送前就被拒绝
+	_, err := c.ScanItems("default", "key1", nil, -1)
+	if !errors.Is(err, ErrInvalidArgument) {
+		return fmt.Errorf("负数 limit 应返回 ErrInvalidArgument, 实际为: %v", err)
+	}
+	fmt.Printf("✓ 负数 limit 被拒绝: %v\n", err)
+
+	// 删除整个列族必须显式确认
+	_, err = c.DeleteRange(ctx, "default", "", nil)
+	if !errors.Is(err, ErrConfirmationRequired) {
+		return fmt.Errorf("未确认的整列族删除应返回 ErrConfirmationRequired, 实际为: %v", err)
+	}
+	fmt.Printf("✓ 未确认的整列族删除被拒绝: %v\n", err)
+
+	// 已取消的 context 在第一页之前就会停止
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	end := "z"
+	_, err = c.DeleteRange(canceled, "default", "a", &end)
+	if !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("取消的 context 应返回 context.Canceled, 实际为: %v", err)
+	}
+	fmt.Printf("✓ DeleteRange 随 context 取消: %v\n", err)
+
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+// info 示例: 服务器信息、刷盘, 以及随 context 结束的 Info 订阅
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+func infoExample(ctx context.Context, c *Client) error {
+	info, err := c.InfoDetailed()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ 服务器信息: 总键数 %d, 列族 %v\n", info.TotalKeys, info.ColumnFamilies)
+
+	if err := c.Flush(); err != nil {
+		return err
+	}
+	fmt.Println("✓ Flush: 数据已刷盘")
+
+	pollCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+
+	deltas, err := c.PollInfo(pollCtx, 20*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	var last InfoDelta
+	for delta := range deltas {
+		last = delta
+	}
+	if !errors.Is(last.Err, context.DeadlineExceeded) {
+		return fmt.Errorf("订阅应因超时结束, 实际为: %v", last.Err)
+	}
+	fmt.Printf("✓ PollInfo 随 context 结束: %v\n", last.Err)
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+// scan 示例: 范围扫描和按游标分页
+
+import (
+	"context"
+	"fmt"
+)
+
+func scanExample(ctx context.Context, c *Client) error {
+	for i := 1; i <= 5; i++ {
+		if err := c.Put("default", fmt.Sprintf("key%d", i), fmt.Sprintf("value%d", i)); err != nil {
+			return err
+		}
+	}
+	fmt.Println("✓ 已插入 key1-key5")
+
+	endKey := "key9"
+	results, err := c.ScanItems("default", "key1", &endKey, 10)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("✓ Scan 结果 (找到 %d 个键):\n", len(results))
+	for _, item := range results {
+		fmt.Printf("  %s = %s\n", item.Key, item.Value)
+	}
+	if len(results) < 5 {
+		return fmt.Errorf("Scan 应至少返回刚写入的 5 个键, 实际为 %d 个", len(results))
+	}
+
+	seen := 0
+	cursor := "key1"
+	for pageNo := 1; ; pageNo++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page, err := c.ScanPage("default", cursor, &endKey, 2)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("✓ 第 %d 页: %d 个键\n", pageNo, len(page.Items))
+		seen += len(page.Items)
+
+		if !page.HasMore {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	if seen != len(results) {
+		return fmt.Errorf("分页共返回 %d 个键, 一次扫描返回 %d 个", seen, len(results))
+	}
+
+	return nil
+}
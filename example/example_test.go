@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// exampleClient 连接一个新的模拟服务器, 关闭调试输出, 使示例的输出保持稳定
+func exampleClient() (*Client, func()) {
+	s, err := startFakeServer()
+	if err != nil {
+		panic(err)
+	}
+	c, err := NewClient(s.addr(), WithDebugOutput(io.Discard))
+	if err != nil {
+		panic(err)
+	}
+	return c, func() {
+		c.Close()
+		s.close()
+	}
+}
+
+func ExampleClient_Get() {
+	c, done := exampleClient()
+	defer done()
+
+	if err := c.Put("default", "name", "Alice"); err != nil {
+		fmt.Println(err)
+		return
+	}
+	value, found, err := c.Get("default", "name")
+	fmt.Println(value, found, err)
+
+	_, found, err = c.Get("default", "missing")
+	fmt.Println(found, err)
+	// Output:
+	// Alice true <nil>
+	// false <nil>
+}
+
+func ExampleClient_ScanPage() {
+	c, done := exampleClient()
+	defer done()
+
+	for _, key := range []string{"a", "b", "c"} {
+		c.Put("default", key, key)
+	}
+
+	cursor := ""
+	for {
+		page, err := c.ScanPage("default", cursor, nil, 2)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		for _, item := range page.Items {
+			fmt.Print(item.Key, " ")
+		}
+		fmt.Println("| more:", page.HasMore)
+		if !page.HasMore {
+			break
+		}
+		cursor = page.NextCursor
+	}
+	// Output:
+	// a b | more: true
+	// c | more: false
+}
+
+func ExampleClient_DeleteRange() {
+	c, done := exampleClient()
+	defer done()
+
+	for _, key := range []string{"user:1", "user:2", "vip:1"} {
+		c.Put("default", key, "x")
+	}
+
+	end := "user;"
+	result, err := c.DeleteRange(context.Background(), "default", "user:", &end)
+	fmt.Println(result.Deleted, err)
+
+	// 不带范围等于删除整个列族, 需要确认
+	_, err = c.DeleteRange(context.Background(), "default", "", nil)
+	fmt.Println(errors.Is(err, ErrConfirmationRequired))
+	// Output:
+	// 2 <nil>
+	// true
+}
+
+func ExampleClient_ScanItems_invalidLimit() {
+	c, done := exampleClient()
+	defer done()
+
+	_, err := c.ScanItems("default", "", nil, -1)
+	fmt.Println(errors.Is(err, ErrInvalidArgument))
+	// Output:
+	// true
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// exampleTimeout 单个示例程序的最长运行时间
+const exampleTimeout = 30 * time.Second
+
+// exampleProgram 一个功能领域的示例程序, 出错时返回错误而不是打印后继续执行
+type exampleProgram struct {
+	name string
+	desc string
+	run  func(ctx context.Context, c *Client) error
+}
+
+// examplePrograms 按运行顺序排列; 每个程序使用独立的客户端
+var examplePrograms = []exampleProgram{
+	{"crud", "基本 Put/Get/Delete", crudExample},
+	{"binary", "二进制键和值", binaryExample},
+	{"batch", "批量写入和范围删除", batchExample},
+	{"scan", "范围扫描和分页", scanExample},
+	{"info", "服务器信息、刷盘和订阅", infoExample},
+	{"errors", "类型化错误和 context", errorsExample},
+}
+
+func exampleNames() []string {
+	names := make([]string, len(examplePrograms))
+	for i, p := range examplePrograms {
+		names[i] = p.name
+	}
+	return names
+}
+
+// runExamples 依次运行指定名称的示例程序, names 为空时运行全部
+func runExamples(addr string, names ...string) error {
+	return runExamplesWith(addr, nil, names...)
+}
+
+// runExamplesWith 与 runExamples 相同, opts 传给每个程序的客户端
+func runExamplesWith(addr string, opts []Option, names ...string) error {
+	programs := examplePrograms
+	if len(names) > 0 {
+		programs = nil
+		for _, name := range names {
+			p, ok := findExample(name)
+			if !ok {
+				return fmt.Errorf("%w: 没有示例 %q, 可选: %s", ErrInvalidArgument, name, strings.Join(exampleNames(), " "))
+			}
+			programs = append(programs, p)
+		}
+	}
+
+	fmt.Println("=== TinyKV Go 客户端示例 ===")
+	for _, p := range programs {
+		fmt.Printf("\n【%s】%s\n", p.name, p.desc)
+		fmt.Println(strings.Repeat("-", 50))
+
+		if err := runExample(addr, p, opts); err != nil {
+			return fmt.Errorf("示例 %s 失败: %w", p.name, err)
+		}
+	}
+	return nil
+}
+
+func findExample(name string) (exampleProgram, bool) {
+	for _, p := range examplePrograms {
+		if p.name == name {
+			return p, true
+		}
+	}
+	return exampleProgram{}, false
+}
+
+func runExample(addr string, p exampleProgram, opts []Option) error {
+	c, err := NewClient(addr, opts...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), exampleTimeout)
+	defer cancel()
+	return p.run(ctx, c)
+}
+
+// expectValue 读取 key 并确认值为 want
+func expectValue(c *Client, cf, key, want string) error {
+	value, found, err := c.Get(cf, key)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("键 %s 不存在", formatKey([]byte(key)))
+	}
+	if value != want {
+		return fmt.Errorf("键 %s 的值为 %q, 期望 %q", formatKey([]byte(key)), value, want)
+	}
+	return nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// heatmapMaxRows 热力图最多的行数, 桶更多时相邻的桶合并为一行
+	heatmapMaxRows = 24
+	// heatmapShades ASCII 热力图由浅到深的字符, 空格表示没有样本
+	heatmapShades = " .:-=+*#%@"
+)
+
+// runLatencyCommand 执行 latency 子命令: render 将 WithLatencyLog 写出的文件绘制为热力图
+func runLatencyCommand(args []string) error {
+	if len(args) == 0 || args[0] != "render" {
+		return fmt.Errorf("%w: 用法: latency render [-html] [-command 命令] <文件>", ErrInvalidArgument)
+	}
+
+	fs := flag.NewFlagSet("latency render", flag.ContinueOnError)
+	asHTML := fs.Bool("html", false, "输出 HTML 表格而不是 ASCII")
+	command := fs.String("command", "", "只绘制该命令类型, 如 Get")
+	if err := fs.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+	}
+	if fs.NArg() != 1 {
+		return fmt.Errorf("%w: 用法: latency render [-html] [-command 命令] <文件>", ErrInvalidArgument)
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hists, err := readLatencyLog(f)
+	if err != nil {
+		return err
+	}
+
+	maps := buildHeatmaps(hists, *command)
+	if *asHTML {
+		return renderHeatmapsHTML(os.Stdout, maps)
+	}
+	renderHeatmapsASCII(os.Stdout, maps)
+	return nil
+}
+
+// readLatencyLog 读取延迟日志, 每行一个区间直方图
+func readLatencyLog(r io.Reader) ([]LatencyHistogram, error) {
+	var hists []LatencyHistogram
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var h LatencyHistogram
+		if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+			return nil, fmt.Errorf("延迟日志第 %d 行: %w", line, err)
+		}
+		if len(h.Counts) != len(h.Bounds)+1 {
+			return nil, fmt.Errorf("延迟日志第 %d 行: %d 个桶, %d 个边界", line, len(h.Counts), len(h.Bounds))
+		}
+		hists = append(hists, h)
+	}
+	return hists, scanner.Err()
+}
+
+// latencyHeatmap 一个客户端一种命令的热力图: 每列是一个区间, 每行是一段延迟, 最慢的在最上面
+type latencyHeatmap struct {
+	Title string
+	Times []time.Time
+	Rows  []string   // 每行的延迟上界
+	Cells [][]uint64 // Cells[行][列]
+	Max   uint64
+}
+
+// buildHeatmaps 按客户端标签和命令类型分组, command 非空时只保留该命令
+func buildHeatmaps(hists []LatencyHistogram, command string) []*latencyHeatmap {
+	groups := map[string][]LatencyHistogram{}
+	var titles []string
+	for _, h := range hists {
+		if command != "" && h.Command != command {
+			continue
+		}
+		title := h.Command
+		if h.Label != "" {
+			title += " [" + h.Label + "]"
+		}
+		if _, ok := groups[title]; !ok {
+			titles = append(titles, title)
+		}
+		groups[title] = append(groups[title], h)
+	}
+	sort.Strings(titles)
+
+	var maps []*latencyHeatmap
+	for _, title := range titles {
+		columns := groups[title]
+		sort.SliceStable(columns, func(i, j int) bool { return columns[i].Time.Before(columns[j].Time) })
+		maps = append(maps, buildHeatmap(title, columns))
+	}
+	return maps
+}
+
+func buildHeatmap(title string, columns []LatencyHistogram) *latencyHeatmap {
+	m := &latencyHeatmap{Title: title}
+
+	// 只保留有样本的桶范围
+	lo, hi := -1, -1
+	for _, col := range columns {
+		for i, n := range col.Counts {
+			if n == 0 {
+				continue
+			}
+			if lo < 0 || i < lo {
+				lo = i
+			}
+			if i > hi {
+				hi = i
+			}
+		}
+	}
+	if lo < 0 {
+		return m
+	}
+
+	per := (hi - lo + heatmapMaxRows) / heatmapMaxRows // 每行合并的桶数
+	bounds := columns[0].Bounds
+	for start := lo; start <= hi; start += per {
+		end := min(start+per, hi+1) // [start, end)
+		if end-1 < len(bounds) {
+			m.Rows = append(m.Rows, "<"+bounds[end-1].String())
+		} else {
+			m.Rows = append(m.Rows, "≥"+bounds[len(bounds)-1].String())
+		}
+
+		row := make([]uint64, len(columns))
+		for c, col := range columns {
+			for i := start; i < end; i++ {
+				row[c] += col.Counts[i]
+			}
+			m.Max = max(m.Max, row[c])
+		}
+		m.Cells = append(m.Cells, row)
+	}
+	for _, col := range columns {
+		m.Times = append(m.Times, col.Time)
+	}
+
+	// 最慢的行在最上面
+	for i, j := 0, len(m.Rows)-1; i < j; i, j = i+1, j-1 {
+		m.Rows[i], m.Rows[j] = m.Rows[j], m.Rows[i]
+		m.Cells[i], m.Cells[j] = m.Cells[j], m.Cells[i]
+	}
+	return m
+}
+
+// shade 按与单格最大计数的比例返回 0 到 1 之间的深浅
+func (m *latencyHeatmap) shade(n uint64) float64 {
+	if n == 0 || m.Max == 0 {
+		return 0
+	}
+	return float64(n) / float64(m.Max)
+}
+
+// renderHeatmapsASCII 每种命令输出一张字符热力图
+func renderHeatmapsASCII(w io.Writer, maps []*latencyHeatmap) {
+	if len(maps) == 0 {
+		fmt.Fprintln(w, "延迟日志中没有样本")
+		return
+	}
+
+	levels := len(heatmapShades) - 1
+	for i, m := range maps {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if len(m.Rows) == 0 {
+			fmt.Fprintf(w, "%s: 没有样本\n", m.Title)
+			continue
+		}
+		fmt.Fprintf(w, "%s  %d 个区间 %s – %s, 单格最大 %d\n", m.Title, len(m.Times),
+			m.Times[0].Format("15:04:05"), m.Times[len(m.Times)-1].Format("15:04:05"), m.Max)
+
+		width := 0
+		for _, r := range m.Rows {
+			width = max(width, len([]rune(r)))
+		}
+		for r, row := range m.Cells {
+			var line strings.Builder
+			for _, n := range row {
+				level := 0 // 有样本的格子至少为最浅的一档
+				if n > 0 {
+					level = 1 + int(m.shade(n)*float64(levels-1)+0.5)
+				}
+				line.WriteByte(heatmapShades[level])
+			}
+			fmt.Fprintf(w, "%*s |%s|\n", width, m.Rows[r], line.String())
+		}
+	}
+	fmt.Fprintf(w, "\n图例: 由浅到深 %q, 按各图单格最大计数归一\n", heatmapShades[1:])
+}
+
+var heatmapHTML = template.Must(template.New("heatmap").Funcs(template.FuncMap{
+	"alpha": func(m *latencyHeatmap, n uint64) string { return fmt.Sprintf("%.3f", m.shade(n)) },
+	"clock": func(t time.Time) string { return t.Format("15:04:05") },
+}).Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>TinyKV 延迟热力图</title>
+<style>
+table { border-collapse: collapse; margin-bottom: 2em; font: 12px monospace; }
+td { width: 10px; height: 14px; padding: 0; }
+th { font-weight: normal; text-align: right; padding-right: 6px; }
+</style></head><body>
+{{range .}}<h3>{{.Title}}</h3>
+{{if .Rows}}<table>
+{{$m := .}}{{range $r, $row := .Cells}}<tr><th>{{index $m.Rows $r}}</th>{{range $c, $n := $row}}<td title="{{clock (index $m.Times $c)}}: {{$n}}" style="background: rgba(200, 30, 30, {{alpha $m $n}})"></td>{{end}}</tr>
+{{end}}</table>
+{{else}}<p>没有样本</p>
+{{end}}{{else}}<p>延迟日志中没有样本</p>
+{{end}}</body></html>
+`))
+
+// renderHeatmapsHTML 输出包含全部热力图的 HTML 页面, 格子的颜色深浅表示计数, 悬停显示时间和计数
+func renderHeatmapsHTML(w io.Writer, maps []*latencyHeatmap) error {
+	return heatmapHTML.Execute(w, maps)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// latencyColumn 用与客户端相同的分桶方式生成一个区间的直方图
+func latencyColumn(at time.Time, label, command string, samples ...time.Duration) LatencyHistogram {
+	rec := newLatencyRecorder(label, defaultLatencyResolution)
+	for _, d := range samples {
+		rec.record(command, d)
+	}
+	return rec.snapshot(at)[0]
+}
+
+func TestLatencyRenderASCII(t *testing.T) {
+	start := time.Date(2026, 1, 2, 15, 4, 0, 0, time.Local)
+	var log bytes.Buffer
+	enc := json.NewEncoder(&log)
+	for _, h := range []LatencyHistogram{
+		latencyColumn(start, "", "Get", time.Millisecond, time.Millisecond, time.Millisecond, time.Millisecond),
+		latencyColumn(start.Add(time.Second), "", "Get", time.Millisecond, 8*time.Millisecond),
+		latencyColumn(start, "", "Put", 2*time.Millisecond),
+	} {
+		enc.Encode(h)
+	}
+
+	hists, err := readLatencyLog(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	maps := buildHeatmaps(hists, "Get")
+	if len(maps) != 1 || maps[0].Title != "Get" || maps[0].Max != 4 {
+		t.Fatalf("%+v", maps)
+	}
+	m := maps[0]
+	// 1ms 到 8ms 之间 3 个 2 倍区间, 每个 4 个桶, 不需要合并
+	if len(m.Rows) != 3*defaultLatencyResolution+1 {
+		t.Fatalf("%d 行: %q", len(m.Rows), m.Rows)
+	}
+
+	var out bytes.Buffer
+	renderHeatmapsASCII(&out, maps)
+	lines := strings.Split(out.String(), "\n")
+	if !strings.HasPrefix(lines[0], "Get  2 个区间 15:04:00 – 15:04:01, 单格最大 4") {
+		t.Fatalf("标题: %q", lines[0])
+	}
+	// 最慢的行在最上面: 第一列没有 8ms 的样本, 最下一行第一列最深
+	if top := lines[1]; !strings.HasSuffix(top, "| -|") {
+		t.Errorf("最上一行: %q", top)
+	}
+	if bottom := lines[len(m.Rows)]; !strings.HasSuffix(bottom, "|@-|") {
+		t.Errorf("最下一行: %q", bottom)
+	}
+}
+
+func TestLatencyRenderMergesRows(t *testing.T) {
+	h := latencyColumn(time.Now(), "", "Scan", time.Microsecond, time.Minute)
+	m := buildHeatmaps([]LatencyHistogram{h}, "")[0]
+	if len(m.Rows) > heatmapMaxRows || len(m.Rows) < heatmapMaxRows/2 {
+		t.Fatalf("%d 行", len(m.Rows))
+	}
+	var total uint64
+	for _, row := range m.Cells {
+		total += row[0]
+	}
+	if total != 2 || m.Cells[0][0] != 1 || m.Cells[len(m.Cells)-1][0] != 1 {
+		t.Fatalf("合并后 %v", m.Cells)
+	}
+}
+
+func TestLatencyRenderHTMLEscapesLabels(t *testing.T) {
+	h := latencyColumn(time.Now(), "<订单>", "Get", time.Millisecond)
+	var out bytes.Buffer
+	if err := renderHeatmapsHTML(&out, buildHeatmaps([]LatencyHistogram{h}, "")); err != nil {
+		t.Fatal(err)
+	}
+	html := out.String()
+	for _, want := range []string{"<table>", "Get [&lt;订单&gt;]", "rgba(200, 30, 30, 1.000)"} {
+		if !strings.Contains(html, want) {
+			t.Errorf("输出缺少 %q:\n%s", want, html)
+		}
+	}
+}
+
+func TestLatencyLogRejectsDamage(t *testing.T) {
+	for _, line := range []string{
+		`{"command":"Get","bounds":[1000],"counts":[1]}`,
+		`{"command":"Get",`,
+	} {
+		if _, err := readLatencyLog(strings.NewReader(line)); err == nil {
+			t.Errorf("%s: 没有报错", line)
+		}
+	}
+}
+
+func TestLatencyCommandArguments(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "latency.log")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, args := range [][]string{
+		{"latency"},
+		{"latency", "draw", path},
+		{"latency", "render"},
+		{"latency", "render", "-svg", path},
+	} {
+		if err := runCommand("127.0.0.1:0", nil, args); !errors.Is(err, ErrInvalidArgument) {
+			t.Errorf("%q: err = %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+// tinykv-go 命令行入口. Go 客户端没有 go.mod, 以文件模式构建和测试:
+//
+//	go build -o tinykv-go ./example/*.go
+//	./tinykv-go [-addr 127.0.0.1:8080] [-capture session.cap] examples [crud binary batch scan info errors]
+//	./tinykv-go capture decode session.cap
+//	./tinykv-go errors 127.0.0.1:6060
+//	./tinykv-go latency render -html latency.log > latency.html
+//	./tinykv-go -addr 127.0.0.1:9090 capture replay --as server session.cap
+//	go test ./example/*.go
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8080", "服务器地址")
+	capturePath := flag.String("capture", "", "将示例程序收发的帧写入该抓包文件")
+	flag.Usage = usage
+	flag.Parse()
+
+	var opts []Option
+	if *capturePath != "" {
+		f, err := os.Create(*capturePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+			os.Exit(1)
+		}
+		defer f.Close()
+		opts = append(opts, WithCapture(f))
+	}
+
+	if err := runCommand(*addr, opts, flag.Args()); err != nil {
+		fmt.Fprintf(os.Stderr, "✗ %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "用法: tinykv-go [-addr 地址] <命令> [参数]\n\n命令:\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  examples [名称...]  对服务器运行示例程序, 不指定名称时全部运行: %s\n",
+		strings.Join(exampleNames(), " "))
+	fmt.Fprintf(flag.CommandLine.Output(), "  capture decode <文件>  逐条打印抓包记录, 标记无法解析的帧\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  capture replay --as client|server <文件>\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "                      client: 向 -addr 发送抓包中的命令并比较响应\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "                      server: 在 -addr 上监听, 按抓包返回响应\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  errors <管理接口地址>  打印客户端管理接口 (WithAdminEndpoint) 上的错误历史\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "  latency render [-html] [-command 命令] <文件>\n")
+	fmt.Fprintf(flag.CommandLine.Output(), "                      将 WithLatencyLog 写出的延迟日志绘制为热力图\n\n")
+	flag.PrintDefaults()
+}
+
+// runCommand 执行一个子命令, 不带参数时运行全部示例; opts 传给示例程序的客户端
+func runCommand(addr string, opts []Option, args []string) error {
+	if len(args) == 0 {
+		return runExamplesWith(addr, opts)
+	}
+
+	switch args[0] {
+	case "examples":
+		return runExamplesWith(addr, opts, args[1:]...)
+	case "capture":
+		return runCaptureCommand(addr, args[1:])
+	case "errors":
+		return runErrorsCommand(args[1:])
+	case "latency":
+		return runLatencyCommand(args[1:])
+	default:
+		return fmt.Errorf("%w: 未知命令 %q", ErrInvalidArgument, args[0])
+	}
+}
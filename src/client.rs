@@ -68,7 +68,7 @@ impl KvClient {
         Ok(())
     }
 
-    /// Scan 操作：范围扫描
+    /// Scan 操作：范围扫描，limit 为 0 表示不限制
     pub fn scan(
         &mut self,
         cf: &str,
@@ -169,7 +169,8 @@ impl StorageReader for StandaloneStorageReader {
             if let Some(original_key) = common::strip_cf_prefix(cf, k) {
                 results.push((original_key.to_vec(), v.clone()));
                 
-                if results.len() >= limit {
+                // limit 为 0 表示不限制
+                if limit > 0 && results.len() >= limit {
                     break;
                 }
             }
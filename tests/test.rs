@@ -54,4 +54,23 @@ mod tests {
         let results = api.raw_scan("default", b"key1", Some(b"key4"), 10).unwrap();
         assert_eq!(results.len(), 3); // key1, key2, key3
     }
+
+    #[test]
+    fn test_scan_zero_limit() {
+        let storage = Arc::new(storage::StandaloneStorage::new());
+        let api = common::RawKeyValueApi::new(storage);
+
+        for i in 0..5 {
+            let key = format!("key{}", i);
+            let value = format!("value{}", i);
+            api.raw_put("default".to_string(), key.into_bytes(), value.into_bytes()).unwrap();
+        }
+
+        // limit 为 0 表示不限制
+        let results = api.raw_scan("default", b"key0", None, 0).unwrap();
+        assert_eq!(results.len(), 5);
+
+        let results = api.raw_scan("default", b"key0", None, 1).unwrap();
+        assert_eq!(results.len(), 1);
+    }
 }
\ No newline at end of file